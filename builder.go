@@ -0,0 +1,123 @@
+package main
+
+// builder module provides construction of CHAP pipeline from reader, list
+// of processors and writer selected from CHAP catalog, e.g.
+//
+//	{"reader": {"class": "csv", "args": {"filename": "data.csv"}},
+//	 "processors": [{"class": "UserProcessor"}, {"class": "PrintProcessor"}],
+//	 "writer": {"class": "common.NexusWriter", "args": {"filename": "out.nxs"}}}
+//
+// The class may be given by its class path, its class name or short name
+// without kind suffix, e.g. common.CSVReader, CSVReader or csv.
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// userProcessor defines class name which refers to processor of the user
+const userProcessor = "UserProcessor"
+
+// defaultClassModule defines CHAP module of classes without known class path
+const defaultClassModule = "common"
+
+// PipelineRequest represents request to build CHAP pipeline, the pipeline
+// is given either by its reader, processors and writer or by ordered list
+// of its items
+type PipelineRequest struct {
+	Workflow   string         `json:"workflow"`   // workflow to save pipeline to, optional
+	Reader     PipelineItem   `json:"reader"`     // reader of the pipeline
+	Processors []PipelineItem `json:"processors"` // processors of the pipeline
+	Writer     PipelineItem   `json:"writer"`     // writer of the pipeline, optional
+	Items      []PipelineItem `json:"items"`      // ordered items of the pipeline
+}
+
+// helper function to resolve class path of pipeline item of given kind
+func resolveClass(catalog Catalog, name, kind string) (string, error) {
+	if strings.Contains(name, ".") {
+		if e, ok := catalog.Lookup(name); ok && kind != "" && e.Kind != kind {
+			return "", fmt.Errorf("%s is %s while %s is expected", name, e.Kind, kind)
+		}
+		return name, nil
+	}
+	for _, key := range []string{name, name + kind} {
+		if e, ok := catalog.Lookup(key); ok && (kind == "" || e.Kind == kind) {
+			if e.Class != "" {
+				return e.Class, nil
+			}
+			return fmt.Sprintf("%s.%s", defaultClassModule, e.Name), nil
+		}
+	}
+	if kind == "" {
+		return "", fmt.Errorf("unknown class '%s'", name)
+	}
+	return "", fmt.Errorf("unknown %s '%s', known %ss are %s", kind, name, kind, strings.Join(catalog.Names(kind), ", "))
+}
+
+// helper function to build CHAP pipeline for given user and request, the
+// UserProcessor class refers to processor of the user module and can be
+// placed at any position of the pipeline items
+func buildPipeline(user, module string, req PipelineRequest) (*Pipeline, error) {
+	if len(req.Items) > 0 {
+		if req.Reader.Class != "" || len(req.Processors) > 0 || req.Writer.Class != "" {
+			return nil, errors.New("pipeline should be given either by its items or by its reader, processors and writer")
+		}
+	} else if req.Reader.Class == "" {
+		return nil, errors.New("pipeline should have a reader")
+	}
+	catalog := getCatalog()
+	pipeline := newPipeline()
+	add := func(item PipelineItem, kind string) error {
+		if item.Class == "" {
+			return errors.New("pipeline item should have a class")
+		}
+		class := userProcessorClass(user, module)
+		if item.Class != userProcessor || kind == KindReader || kind == KindWriter {
+			if kind == "" {
+				// the kind of pipeline item is defined by its class name
+				kind = classKind(className(item.Class))
+			}
+			var err error
+			if class, err = resolveClass(catalog, item.Class, kind); err != nil {
+				return err
+			}
+		}
+		var args any
+		if len(item.Args) > 0 {
+			args = item.Args
+		}
+		return pipeline.Append(class, args)
+	}
+	for _, item := range req.Items {
+		if err := add(item, ""); err != nil {
+			return nil, err
+		}
+	}
+	if req.Reader.Class != "" {
+		if err := add(req.Reader, KindReader); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range req.Processors {
+		if err := add(item, KindProcessor); err != nil {
+			return nil, err
+		}
+	}
+	if req.Writer.Class != "" {
+		if err := add(req.Writer, KindWriter); err != nil {
+			return nil, err
+		}
+	}
+	data, err := pipeline.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := validateConfig(string(data)); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper function to setup CHAP catalog from given docs, it returns
+// function which resets the catalog
+func setupCatalog(t *testing.T, docs map[string]string) func() {
+	dir := t.TempDir()
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Config.DocDir = dir
+	catalogCache.catalog = buildCatalog()
+	catalogCache.expire = time.Now().Add(time.Hour)
+	return func() {
+		Config.DocDir = ""
+		catalogCache.catalog, catalogCache.expire = Catalog{}, time.Time{}
+	}
+}
+
+// TestBuildPipeline tests construction of CHAP pipeline from catalog classes
+func TestBuildPipeline(t *testing.T) {
+	docs := map[string]string{
+		"YamlReader.md":     "### YamlReader\n\nClass: `common.YAMLReader`\n\nRequired arguments: `filename`\n",
+		"CSVReader.md":      "### CSVReader\n",
+		"NexusWriter.md":    "### NexusWriter\n",
+		"PrintProcessor.md": "### PrintProcessor\n",
+	}
+	defer setupCatalog(t, docs)()
+
+	req := PipelineRequest{
+		Reader: PipelineItem{Class: "yaml", Args: map[string]any{"filename": "data.yaml"}},
+		Processors: []PipelineItem{
+			{Class: userProcessor},
+			{Class: "PrintProcessor", Args: map[string]any{"verbose": true}},
+		},
+		Writer: PipelineItem{Class: "nexus", Args: map[string]any{"filename": "out.nxs"}},
+	}
+	pipeline, err := buildPipeline("test", "userprocessor", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"common.YAMLReader", "users.test.userprocessor.UserProcessor", "common.PrintProcessor", "common.NexusWriter"}
+	if classes := pipeline.Classes(); strings.Join(classes, ",") != strings.Join(expect, ",") {
+		t.Errorf("wrong pipeline %v, expect %v", classes, expect)
+	}
+	if items := pipeline.Items(); items[2].Args["verbose"] != true || items[3].Args["filename"] != "out.nxs" {
+		t.Errorf("wrong pipeline arguments %+v", items)
+	}
+
+	// reader by class name with user processor and without writer
+	req = PipelineRequest{
+		Reader:     PipelineItem{Class: "CSVReader"},
+		Processors: []PipelineItem{{Class: userProcessor}, {Class: "PrintProcessor"}},
+	}
+	if pipeline, err = buildPipeline("test", "userprocessor", req); err != nil {
+		t.Fatal(err)
+	}
+	expect = []string{"common.CSVReader", "users.test.userprocessor.UserProcessor", "common.PrintProcessor"}
+	if classes := pipeline.Classes(); strings.Join(classes, ",") != strings.Join(expect, ",") {
+		t.Errorf("wrong pipeline %v, expect %v", classes, expect)
+	}
+
+	// invalid requests
+	for _, req := range []PipelineRequest{
+		{},
+		{Reader: PipelineItem{Class: "spec"}},
+		{Reader: PipelineItem{Class: "common.NexusWriter"}},
+		{Reader: PipelineItem{Class: "csv"}, Processors: []PipelineItem{{}}},
+		{Reader: PipelineItem{Class: "csv"}, Writer: PipelineItem{Class: "csv"}},
+	} {
+		if _, err := buildPipeline("test", "userprocessor", req); err == nil {
+			t.Errorf("no error for invalid request %+v", req)
+		}
+	}
+
+	// missing required argument may be provided at run time
+	if _, err := buildPipeline("test", "userprocessor", PipelineRequest{Reader: PipelineItem{Class: "yaml"}}); err != nil {
+		t.Errorf("missing required argument is not a warning, error %v", err)
+	}
+}
+
+// TestBuildPipelineItems tests construction of CHAP pipeline from its items
+func TestBuildPipelineItems(t *testing.T) {
+	docs := map[string]string{
+		"YamlReader.md":     "### YamlReader\n\nClass: `common.YAMLReader`\n",
+		"NexusWriter.md":    "### NexusWriter\n",
+		"PrintProcessor.md": "### PrintProcessor\n",
+	}
+	defer setupCatalog(t, docs)()
+
+	// user processor can be placed at any position
+	req := PipelineRequest{Items: []PipelineItem{
+		{Class: userProcessor},
+		{Class: "YamlReader", Args: map[string]any{"filename": "data.yaml"}},
+		{Class: "PrintProcessor"},
+		{Class: userProcessor},
+		{Class: "edd.StrainProcessor"},
+		{Class: "NexusWriter"},
+	}}
+	pipeline, err := buildPipeline("test", "userprocessor", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := "users.test.userprocessor.UserProcessor"
+	expect := []string{user, "common.YAMLReader", "common.PrintProcessor", user, "edd.StrainProcessor", "common.NexusWriter"}
+	if classes := pipeline.Classes(); strings.Join(classes, ",") != strings.Join(expect, ",") {
+		t.Errorf("wrong pipeline %v, expect %v", classes, expect)
+	}
+
+	for _, req := range []PipelineRequest{
+		{Items: []PipelineItem{{Class: "YamlReader"}}, Writer: PipelineItem{Class: "NexusWriter"}},
+		{Items: []PipelineItem{{Class: "NexusWriter"}, {Class: "YamlReader"}}},
+		{Items: []PipelineItem{{Class: "SpecReader"}}},
+		{Items: []PipelineItem{{}}},
+	} {
+		if _, err := buildPipeline("test", "userprocessor", req); err == nil {
+			t.Errorf("no error for invalid request %+v", req)
+		}
+	}
+}
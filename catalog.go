@@ -0,0 +1,345 @@
+package main
+
+// catalog module provides catalog of CHAP readers, writers and processors
+// and validation of CHAP pipelines against it, the catalog is built from
+// documentation in DocDir and optionally from JSON catalog produced by
+// introspection of installed CHAP package (see scripts/catalog.py)
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// list of catalog entry kinds
+const (
+	KindReader    = "reader"
+	KindWriter    = "writer"
+	KindProcessor = "processor"
+)
+
+// list of pipeline issue severities
+const (
+	SeverityError   = "error"   // pipeline can not be run
+	SeverityWarning = "warning" // pipeline may fail
+)
+
+// CatalogEntry represents CHAP reader, writer or processor
+type CatalogEntry struct {
+	Name     string   `json:"name"`     // class name, e.g. YAMLReader
+	Class    string   `json:"class"`    // class path if known, e.g. common.YAMLReader
+	Kind     string   `json:"kind"`     // reader, writer or processor
+	Required []string `json:"required"` // required arguments
+}
+
+// Catalog represents catalog of CHAP classes keyed by lower case class name
+type Catalog struct {
+	Entries  map[string]CatalogEntry
+	Complete bool // catalog is introspected from CHAP package
+}
+
+// PipelineIssue represents problem of pipeline item
+type PipelineIssue struct {
+	Line     int    `json:"line"`     // line number of the item in configuration
+	Item     string `json:"item"`     // class path of the item
+	Severity string `json:"severity"` // error or warning
+	Message  string `json:"message"`  // description of the problem
+}
+
+// String returns string representation of pipeline issue
+func (i PipelineIssue) String() string {
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Severity, i.Message)
+}
+
+// catalogCache holds CHAP catalog and its expiration time
+var catalogCache struct {
+	sync.Mutex
+	catalog Catalog
+	expire  time.Time
+}
+
+// helper function to determine kind of CHAP class from its name
+func classKind(name string) string {
+	if strings.HasSuffix(name, "Reader") {
+		return KindReader
+	} else if strings.HasSuffix(name, "Writer") {
+		return KindWriter
+	} else if strings.HasSuffix(name, "Processor") {
+		return KindProcessor
+	}
+	return ""
+}
+
+// helper function to get class name from class path
+func className(class string) string {
+	return class[strings.LastIndex(class, ".")+1:]
+}
+
+// helper function to read class path and required arguments of CHAP class
+// from its documentation, the documentation may provide them in lines like
+// Class: `common.YAMLReader`
+// Required arguments: `filename`, `schema`
+func docEntry(fname string) (string, []string) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return "", nil
+	}
+	defer file.Close()
+	var class string
+	var required []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(strings.ToLower(line), "class:") {
+			class = strings.Trim(strings.TrimSpace(line[len("class:"):]), "`")
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(line), "required arguments:") {
+			continue
+		}
+		for _, arg := range strings.Split(line[len("required arguments:"):], ",") {
+			if arg = strings.Trim(strings.TrimSpace(arg), "`"); arg != "" {
+				required = append(required, arg)
+			}
+		}
+	}
+	return class, required
+}
+
+// helper function to build CHAP catalog
+func buildCatalog() Catalog {
+	catalog := Catalog{Entries: make(map[string]CatalogEntry)}
+	if files, err := os.ReadDir(Config.DocDir); err == nil {
+		for _, f := range files {
+			name := strings.TrimSuffix(f.Name(), ".md")
+			if kind := classKind(name); kind != "" && strings.HasSuffix(f.Name(), ".md") {
+				class, required := docEntry(filepath.Join(Config.DocDir, f.Name()))
+				if class != "" {
+					name = className(class)
+				}
+				catalog.Entries[strings.ToLower(name)] = CatalogEntry{
+					Name:     name,
+					Class:    class,
+					Kind:     kind,
+					Required: required,
+				}
+			}
+		}
+	} else {
+		log.Printf("ERROR: unable to read CHAP docs from %s, error %v", Config.DocDir, err)
+	}
+	if Config.CatalogFile == "" {
+		return catalog
+	}
+	data, err := os.ReadFile(Config.CatalogFile)
+	if err != nil {
+		log.Printf("ERROR: unable to read CHAP catalog %s, error %v", Config.CatalogFile, err)
+		return catalog
+	}
+	var entries []CatalogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("ERROR: unable to parse CHAP catalog %s, error %v", Config.CatalogFile, err)
+		return catalog
+	}
+	for _, e := range entries {
+		if e.Name == "" {
+			e.Name = className(e.Class)
+		}
+		if e.Kind == "" {
+			e.Kind = classKind(e.Name)
+		}
+		catalog.Entries[strings.ToLower(e.Name)] = e
+	}
+	catalog.Complete = true
+	return catalog
+}
+
+// helper function to get CHAP catalog, the catalog is cached for an hour
+func getCatalog() Catalog {
+	catalogCache.Lock()
+	defer catalogCache.Unlock()
+	if catalogCache.expire.Before(time.Now()) {
+		catalogCache.catalog = buildCatalog()
+		catalogCache.expire = time.Now().Add(1 * time.Hour)
+	}
+	return catalogCache.catalog
+}
+
+// Lookup finds catalog entry of given class path
+func (c Catalog) Lookup(class string) (CatalogEntry, bool) {
+	e, ok := c.Entries[strings.ToLower(className(class))]
+	return e, ok
+}
+
+// Names returns sorted names of catalog entries of given kind
+func (c Catalog) Names(kind string) []string {
+	var names []string
+	for _, e := range c.Entries {
+		if e.Kind == kind {
+			names = append(names, e.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns catalog entries sorted by their kind and name
+func (c Catalog) List() []CatalogEntry {
+	var entries []CatalogEntry
+	for _, e := range c.Entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Suggest returns name of known class closest to given class path
+func (c Catalog) Suggest(class string) string {
+	name := strings.ToLower(className(class))
+	best, distance := "", 3
+	var names []string
+	for key := range c.Entries {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		if d := editDistance(name, key); d < distance {
+			best, distance = c.Entries[key].Name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	if e := c.Entries[strings.ToLower(best)]; e.Class != "" {
+		return e.Class
+	}
+	if idx := strings.LastIndex(class, "."); idx != -1 {
+		return class[:idx+1] + best
+	}
+	return best
+}
+
+// helper function to calculate Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// helper function to find minimum of integers
+func minInt(vals ...int) int {
+	m := vals[0]
+	for _, v := range vals[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Validate checks pipeline items against the catalog, it reports unknown
+// classes, readers placed after writers and missing required arguments,
+// the user processors are not validated. The unknown classes and missing
+// required arguments are errors only if catalog is complete.
+func (c Catalog) Validate(p *Pipeline) []PipelineIssue {
+	var issues []PipelineIssue
+	writer := ""
+	for _, item := range p.Items() {
+		if strings.HasPrefix(item.Class, "users.") {
+			continue
+		}
+		entry, ok := c.Lookup(item.Class)
+		kind := classKind(className(item.Class))
+		if ok {
+			kind = entry.Kind
+		}
+		if !ok && len(c.Entries) > 0 {
+			issue := PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityWarning,
+				Message: fmt.Sprintf("unknown class %s", item.Class)}
+			if suggestion := c.Suggest(item.Class); suggestion != "" {
+				issue.Message += fmt.Sprintf(", did you mean %s?", suggestion)
+			}
+			// incomplete catalog may not know the class, e.g. new CHAP
+			// processor with name close to existing one
+			if c.Complete {
+				issue.Severity = SeverityError
+			}
+			issues = append(issues, issue)
+		} else if ok && entry.Class != "" && entry.Class != item.Class {
+			issues = append(issues, PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityError,
+				Message: fmt.Sprintf("unknown class %s, did you mean %s?", item.Class, entry.Class)})
+		}
+		if kind == KindWriter && writer == "" {
+			writer = item.Class
+		}
+		if kind == KindReader && writer != "" {
+			issues = append(issues, PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityError,
+				Message: fmt.Sprintf("reader %s is placed after writer %s", item.Class, writer)})
+		}
+		for _, arg := range entry.Required {
+			if _, ok := item.Args[arg]; !ok {
+				// the documented arguments may be provided at run time,
+				// e.g. filename of common.YAMLReader
+				issue := PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityWarning,
+					Message: fmt.Sprintf("missing required argument '%s' of %s", arg, item.Class)}
+				if c.Complete {
+					issue.Severity = SeverityError
+				}
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues
+}
+
+// helper function to validate CHAP configuration against CHAP catalog, it
+// returns pipeline issues and error if pipeline can not be run
+func validateConfig(config string) ([]PipelineIssue, error) {
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		return nil, err
+	}
+	issues := getCatalog().Validate(pipeline)
+	var errs []string
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.String())
+		} else if Config.Verbose > 0 {
+			log.Printf("WARNING: CHAP pipeline %s", issue)
+		}
+	}
+	if len(errs) > 0 {
+		return issues, errors.New("invalid CHAP pipeline, " + strings.Join(errs, "; "))
+	}
+	return issues, nil
+}
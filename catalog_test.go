@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCatalog tests validation of CHAP pipeline against CHAP catalog
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	docs := map[string]string{
+		"YamlReader.md":     "### YamlReader\n\nRequired arguments: `filename`\n",
+		"NexusReader.md":    "### NexusReader\n",
+		"NexusWriter.md":    "### NexusWriter\n",
+		"PrintProcessor.md": "### PrintProcessor\n",
+		"README":            "not a class",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Config.DocDir = dir
+	defer func() { Config.DocDir = "" }()
+	catalog := buildCatalog()
+	if len(catalog.Entries) != 4 || catalog.Complete {
+		t.Fatalf("wrong catalog %+v", catalog)
+	}
+	if e, ok := catalog.Lookup("common.YAMLReader"); !ok || e.Kind != KindReader || len(e.Required) != 1 {
+		t.Errorf("wrong catalog entry %+v", e)
+	}
+
+	config := `pipeline:
+  - common.YAMLReader:
+      filename: data.yaml
+  - common.NexuWriter: {}
+  - common.NexusReader
+  - edd.StrainProcessor
+  - users.test.userprocessor.UserProcessor
+  - common.YAMLReader:
+      schema: MapConfig
+`
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := catalog.Validate(pipeline)
+	expect := []PipelineIssue{
+		{Line: 4, Severity: SeverityWarning, Message: "unknown class common.NexuWriter, did you mean common.NexusWriter?"},
+		{Line: 5, Severity: SeverityError, Message: "reader common.NexusReader is placed after writer common.NexuWriter"},
+		{Line: 6, Severity: SeverityWarning, Message: "unknown class edd.StrainProcessor"},
+		{Line: 8, Severity: SeverityError, Message: "reader common.YAMLReader is placed after writer common.NexuWriter"},
+		{Line: 8, Severity: SeverityWarning, Message: "missing required argument 'filename' of common.YAMLReader"},
+	}
+	if len(issues) != len(expect) {
+		t.Fatalf("wrong number of issues %v", issues)
+	}
+	for i, issue := range issues {
+		if issue.Line != expect[i].Line || issue.Severity != expect[i].Severity || issue.Message != expect[i].Message {
+			t.Errorf("wrong issue %v, expect %v", issue, expect[i])
+		}
+	}
+
+	// complete catalog reports unknown classes and missing required
+	// arguments as errors
+	catalog.Complete = true
+	for _, class := range []string{"edd.StrainProcessor", "common.NexuWriter", "common.YAMLReader: {}"} {
+		pipeline, _ = parsePipeline([]byte("pipeline:\n  - " + class + "\n"))
+		if issues := catalog.Validate(pipeline); len(issues) != 1 || issues[0].Severity != SeverityError {
+			t.Errorf("wrong issues %v", issues)
+		}
+	}
+
+	// warnings do not prevent the run while errors do
+	catalogCache.catalog = buildCatalog()
+	catalogCache.expire = time.Now().Add(time.Hour)
+	defer func() { catalogCache.catalog, catalogCache.expire = Catalog{}, time.Time{} }()
+	for _, class := range []string{"edd.StrainProcessor", "common.NexuWriter", "common.YAMLReader: {}"} {
+		if _, err := validateConfig("pipeline:\n  - " + class + "\n"); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := validateConfig(config); err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("wrong validation error %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+// chain module provides multi-stage pipelines which chain several CHAP
+// workflows into directed acyclic graph, the stages are executed in
+// dependency order and outputs of upstream stages become inputs of
+// downstream ones
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chainsBucket defines storage bucket of workflow chains
+const chainsBucket = "chains"
+
+// list of chain stage states in addition to job states
+const (
+	StagePending = "pending" // stage waits for its upstream stages
+	StageSkipped = "skipped" // stage is not executed due to upstream failure
+	StageReused  = "reused"  // stage reuses outputs of previous chain run
+)
+
+// ChainStage defines stage of workflow chain
+type ChainStage struct {
+	Name     string   `json:"name"`     // stage name
+	Workflow string   `json:"workflow"` // workflow of the stage
+	Depends  []string `json:"depends"`  // names of upstream stages
+}
+
+// ChainRequest represents request to run workflow chain
+type ChainRequest struct {
+	Name     string       `json:"name"`     // chain name
+	Executor string       `json:"executor"` // executor name
+	Stages   []ChainStage `json:"stages"`   // stages of the chain
+}
+
+// ChainStageRun represents execution of chain stage
+type ChainStageRun struct {
+	ChainStage
+	JobID  string `json:"job_id"` // job id of the stage run
+	Status string `json:"status"` // stage status
+	Error  string `json:"error"`  // stage error
+}
+
+// Done returns true if stage reached its final state
+func (s ChainStageRun) Done() bool {
+	switch s.Status {
+	case JobFinished, JobFailed, JobCancelled, StageSkipped, StageReused:
+		return true
+	}
+	return false
+}
+
+// Succeeded returns true if stage outputs are available for downstream stages
+func (s ChainStageRun) Succeeded() bool {
+	return s.Status == JobFinished || s.Status == StageReused
+}
+
+// Chain represents run of workflow chain
+type Chain struct {
+	ID         string          `json:"id"`          // chain identifier
+	User       string          `json:"user"`        // user name
+	Name       string          `json:"name"`        // chain name
+	Executor   string          `json:"executor"`    // requested executor
+	Module     string          `json:"module"`      // user python module
+	Processor  string          `json:"processor"`   // user processor class
+	Lines      []string        `json:"lines"`       // user code captured at submission time
+	Parent     string          `json:"parent"`      // chain which was rerun by this one
+	Status     string          `json:"status"`      // chain status
+	SubmitTime time.Time       `json:"submit_time"` // submission time
+	EndTime    time.Time       `json:"end_time"`    // end time
+	Stages     []ChainStageRun `json:"stages"`      // stages of the chain
+}
+
+// Done returns true if chain reached its final state
+func (c Chain) Done() bool {
+	return c.Status == JobFinished || c.Status == JobFailed
+}
+
+// Validate checks chain request
+func (c ChainRequest) Validate() error {
+	if len(c.Stages) == 0 {
+		return errors.New("no chain stages are provided")
+	}
+	for _, s := range c.Stages {
+		if s.Name == "" || s.Workflow == "" {
+			return errors.New("chain stage should specify its name and workflow")
+		}
+		if _, ok := findWorkflow(s.Workflow); !ok {
+			return fmt.Errorf("unknown workflow %s of stage %s", s.Workflow, s.Name)
+		}
+	}
+	_, err := chainOrder(c.Stages)
+	return err
+}
+
+// helper function to find topological order of chain stages, it returns
+// indexes of stages where every stage follows its upstream stages
+func chainOrder(stages []ChainStage) ([]int, error) {
+	index := make(map[string]int)
+	for i, s := range stages {
+		if _, ok := index[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate chain stage %s", s.Name)
+		}
+		index[s.Name] = i
+	}
+	indegree := make([]int, len(stages))
+	downstream := make([][]int, len(stages))
+	for i, s := range stages {
+		for _, dep := range s.Depends {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("stage %s depends on unknown stage %s", s.Name, dep)
+			}
+			indegree[i]++
+			downstream[j] = append(downstream[j], i)
+		}
+	}
+	var order, ready []int
+	for i := range stages {
+		if indegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+		for _, j := range downstream[i] {
+			indegree[j]--
+			if indegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(order) != len(stages) {
+		return nil, errors.New("chain stages have circular dependencies")
+	}
+	return order, nil
+}
+
+// helper function to find given stage and all its downstream stages
+func chainDownstream(stages []ChainStage, name string) map[string]bool {
+	found := map[string]bool{name: true}
+	for changed := true; changed; {
+		changed = false
+		for _, s := range stages {
+			if found[s.Name] {
+				continue
+			}
+			for _, dep := range s.Depends {
+				if found[dep] {
+					found[s.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return found
+}
+
+// helper function to save chain in storage
+func saveChain(chain Chain) error {
+	return storage.Put(chain.ID, chain, chainsBucket, chain.User)
+}
+
+// helper function to get chain of the user
+func getChain(user, id string) (Chain, error) {
+	var chain Chain
+	err := storage.Get(id, &chain, chainsBucket, user)
+	return chain, err
+}
+
+// helper function to get chains of the user, most recent first
+func userChains(user string) ([]Chain, error) {
+	var chains []Chain
+	err := storage.ForEach(func(key string, data []byte) error {
+		var chain Chain
+		if err := json.Unmarshal(data, &chain); err != nil {
+			return err
+		}
+		chains = append(chains, chain)
+		return nil
+	}, chainsBucket, user)
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].SubmitTime.After(chains[j].SubmitTime)
+	})
+	return chains, err
+}
+
+// helper function to get runs whose outputs are used by chains of the user,
+// such runs can be reused by chain reruns, it returns map of run ids and
+// their chain ids
+func chainRuns(user string) (map[string]string, error) {
+	chains, err := userChains(user)
+	if err != nil {
+		return nil, err
+	}
+	runs := make(map[string]string)
+	for _, chain := range chains {
+		for _, s := range chain.Stages {
+			if s.JobID != "" && s.Succeeded() {
+				runs[s.JobID] = chain.ID
+			}
+		}
+	}
+	return runs, nil
+}
+
+// helper function to submit workflow chain
+func submitChain(user, module, processor string, lines []string, req ChainRequest) (Chain, error) {
+	chain := Chain{
+		ID:         newJobID(),
+		User:       user,
+		Name:       req.Name,
+		Executor:   req.Executor,
+		Module:     module,
+		Processor:  processor,
+		Lines:      lines,
+		Status:     JobRunning,
+		SubmitTime: time.Now(),
+	}
+	if err := req.Validate(); err != nil {
+		return chain, err
+	}
+	if err := checkQuota(user); err != nil {
+		return chain, err
+	}
+	for _, s := range req.Stages {
+		job, err := newJob(user, s.Workflow, module, processor, lines, req.Executor)
+		if err == nil {
+			err = validateJob(job)
+		}
+		if err != nil {
+			return chain, fmt.Errorf("stage %s: %v", s.Name, err)
+		}
+		chain.Stages = append(chain.Stages, ChainStageRun{ChainStage: s, Status: StagePending})
+	}
+	if err := saveChain(chain); err != nil {
+		return chain, err
+	}
+	log.Printf("chain %s of user %s submitted with %d stages", chain.ID, user, len(chain.Stages))
+	go runChain(chain)
+	return chain, nil
+}
+
+// helper function to rerun chain starting from given stage, the stage and
+// all its downstream stages are executed again while other stages reuse
+// outputs of the original chain
+func rerunChain(user, id, stage string) (Chain, error) {
+	orig, err := getChain(user, id)
+	if err != nil {
+		return orig, fmt.Errorf("unable to find chain %s, error %v", id, err)
+	}
+	if !orig.Done() {
+		return orig, fmt.Errorf("chain %s is still %s", id, orig.Status)
+	}
+	var stages []ChainStage
+	found := false
+	for _, s := range orig.Stages {
+		stages = append(stages, s.ChainStage)
+		found = found || s.Name == stage
+	}
+	if !found {
+		return orig, fmt.Errorf("chain %s does not have stage %s", id, stage)
+	}
+	if err := checkQuota(user); err != nil {
+		return orig, err
+	}
+	rerun := chainDownstream(stages, stage)
+	upstream := make(map[string]bool)
+	for _, s := range orig.Stages {
+		if s.Name == stage {
+			for _, dep := range s.Depends {
+				upstream[dep] = true
+			}
+		}
+	}
+	chain := orig
+	chain.ID = newJobID()
+	chain.Parent = orig.ID
+	chain.Status = JobRunning
+	chain.SubmitTime = time.Now()
+	chain.EndTime = time.Time{}
+	chain.Stages = nil
+	for _, s := range orig.Stages {
+		run := ChainStageRun{ChainStage: s.ChainStage, Status: StagePending}
+		if !rerun[s.Name] && s.Succeeded() {
+			run.JobID = s.JobID
+			run.Status = StageReused
+		} else if upstream[s.Name] {
+			return orig, fmt.Errorf("upstream stage %s of chain %s is %s", s.Name, id, s.Status)
+		}
+		chain.Stages = append(chain.Stages, run)
+	}
+	if err := saveChain(chain); err != nil {
+		return chain, err
+	}
+	log.Printf("chain %s of user %s reruns chain %s from stage %s", chain.ID, user, id, stage)
+	go runChain(chain)
+	return chain, nil
+}
+
+// helper function to obtain status of stage job
+func stageJobStatus(user, id string) (string, string) {
+	if job, ok := jobManager.Get(id); ok {
+		return job.Status, job.Error
+	}
+	if rec, err := getRun(user, id); err == nil {
+		return rec.Status, rec.Reason
+	}
+	return JobFailed, fmt.Sprintf("unable to find job %s", id)
+}
+
+// helper function to submit job of chain stage, the run directories of
+// upstream stages become inputs of the job
+func submitStage(chain Chain, stage ChainStageRun, inputs []string) (string, error) {
+	if err := checkQuota(chain.User); err != nil {
+		return "", err
+	}
+	executor := selectExecutor(chain.Executor, stage.Workflow)
+	if _, err := getExecutor(executor); err != nil {
+		return "", err
+	}
+	job, err := newJob(chain.User, stage.Workflow, chain.Module, chain.Processor, chain.Lines, executor)
+	if err != nil {
+		return "", err
+	}
+	job.Chain = chain.ID
+	job.Inputs = inputs
+	if err := jobManager.Submit(job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// helper function to advance chain execution, it updates status of active
+// stages and submits stages whose upstream stages are finished, it returns
+// true if chain was changed
+func advanceChain(chain *Chain) bool {
+	var stages []ChainStage
+	index := make(map[string]int)
+	for i, s := range chain.Stages {
+		stages = append(stages, s.ChainStage)
+		index[s.Name] = i
+	}
+	order, err := chainOrder(stages)
+	if err != nil {
+		log.Printf("ERROR: invalid chain %s, error %v", chain.ID, err)
+		order = nil
+	}
+	changed := false
+	for _, i := range order {
+		stage := &chain.Stages[i]
+		switch stage.Status {
+		case JobQueued, JobRunning:
+			status, msg := stageJobStatus(chain.User, stage.JobID)
+			if status != stage.Status {
+				stage.Status, stage.Error = status, msg
+				changed = true
+			}
+		case StagePending:
+			var inputs []string
+			ready := true
+			for _, dep := range stage.Depends {
+				upstream := chain.Stages[index[dep]]
+				if upstream.Done() && !upstream.Succeeded() {
+					stage.Status = StageSkipped
+					stage.Error = fmt.Sprintf("upstream stage %s is %s", dep, upstream.Status)
+					break
+				}
+				if !upstream.Succeeded() {
+					ready = false
+					continue
+				}
+				inputs = append(inputs, runDir(chain.User, upstream.Workflow, upstream.JobID))
+			}
+			if stage.Status == StageSkipped {
+				changed = true
+				continue
+			}
+			if !ready {
+				continue
+			}
+			id, err := submitStage(*chain, *stage, inputs)
+			if err != nil {
+				stage.Status, stage.Error = JobFailed, err.Error()
+				log.Printf("ERROR: chain %s unable to submit stage %s, error %v", chain.ID, stage.Name, err)
+			} else {
+				stage.JobID, stage.Status = id, JobQueued
+				log.Printf("chain %s submitted stage %s job %s", chain.ID, stage.Name, id)
+			}
+			changed = true
+		}
+	}
+	done, failed := true, order == nil
+	for _, s := range chain.Stages {
+		done = done && s.Done()
+		failed = failed || (s.Done() && !s.Succeeded())
+	}
+	if done || order == nil {
+		chain.Status = JobFinished
+		if failed {
+			chain.Status = JobFailed
+		}
+		chain.EndTime = time.Now()
+		changed = true
+	}
+	return changed
+}
+
+// helper function to execute chain until all its stages are finished
+func runChain(chain Chain) {
+	interval := time.Duration(Config.PollInterval) * time.Second
+	for !chain.Done() {
+		if advanceChain(&chain) {
+			if err := saveChain(chain); err != nil {
+				log.Printf("ERROR: unable to save chain %s, error %v", chain.ID, err)
+			}
+		}
+		if !chain.Done() {
+			time.Sleep(interval)
+		}
+	}
+	log.Printf("chain %s of user %s is %s", chain.ID, chain.User, chain.Status)
+}
+
+// helper function to resume chains which were active during server
+// shutdown, the stages whose jobs were lost fail and their downstream
+// stages are skipped
+func initChains() {
+	users, err := storage.Buckets(chainsBucket)
+	if err != nil {
+		log.Println("ERROR: unable to get users of chains", err)
+		return
+	}
+	for _, user := range users {
+		chains, err := userChains(user)
+		if err != nil {
+			log.Printf("ERROR: unable to get chains of user %s, error %v", user, err)
+			continue
+		}
+		for _, chain := range chains {
+			if !chain.Done() {
+				go runChain(chain)
+			}
+		}
+	}
+}
+
+// helper function to check if file of run directory is produced by the
+// server or executor rather than by CHAP pipeline
+func isRunFile(name, module string) bool {
+	switch name {
+	case "chap.log", "run-chap.yaml", manifestFile, "batch_submit.sh", "batch.out", "batch.err",
+		profileData, profileReport, profileStacks, module + ".py":
+		return true
+	}
+	return strings.HasPrefix(name, "chap.attempt-") && strings.HasSuffix(name, ".log")
+}
+
+// helper function to copy outputs of upstream runs into run directory of
+// the job, the workflow files and files produced by the server are skipped
+func stageInputs(job Job) error {
+	for _, dir := range job.Inputs {
+		manifest, err := readManifest(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read manifest of upstream run %s, error %v", dir, err)
+		}
+		wflowDir := filepath.Join(Config.WorkflowsRoot, manifest.Workflow)
+		for _, file := range manifest.Files {
+			if isRunFile(file.Path, job.Module) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(wflowDir, file.Path)); err == nil {
+				continue
+			}
+			src := filepath.Join(dir, filepath.FromSlash(file.Path))
+			dst := filepath.Join(job.Dir, filepath.FromSlash(file.Path))
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+		}
+		log.Printf("job %s staged inputs from %s", job.ID, dir)
+	}
+	return nil
+}
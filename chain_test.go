@@ -0,0 +1,182 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// helper function to setup storage, job manager and workflows of test
+// chains, the fake CHAP script lists outputs staged from upstream stages
+// and produces output named after its workflow, the broken workflow fails
+func setupChain(t *testing.T) {
+	setupStorage(t)
+	setupJobManager(t, fakeChap(t, `ls *.out > inputs.txt 2>/dev/null
+echo "output of $1" > $1.out
+if [ "$1" == "broken" ]; then
+    exit 2
+fi`))
+	for _, name := range []string{"calibrate", "integrate", "broken"} {
+		testWorkflow(t, name, "pipeline:\n  - common.PrintProcessor\n")
+	}
+}
+
+// helper function to create test chain where second stage depends on first one
+func testChain(first, second string) Chain {
+	return Chain{
+		ID:         newJobID(),
+		User:       "test",
+		Executor:   "local",
+		Module:     "mod",
+		Processor:  "UserProcessor",
+		Status:     JobRunning,
+		SubmitTime: time.Now(),
+		Stages: []ChainStageRun{
+			{ChainStage: ChainStage{Name: first, Workflow: first}, Status: StagePending},
+			{ChainStage: ChainStage{Name: second, Workflow: second, Depends: []string{first}}, Status: StagePending},
+		},
+	}
+}
+
+// helper function to advance chain until it is finished
+func driveChain(t *testing.T, chain *Chain) {
+	deadline := time.Now().Add(20 * time.Second)
+	for !chain.Done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("chain %s is not finished, stages %+v", chain.ID, chain.Stages)
+		}
+		advanceChain(chain)
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// helper function to read file of stage run directory
+func stageFile(t *testing.T, stage ChainStageRun, name string) string {
+	data, err := os.ReadFile(filepath.Join(runDir("test", stage.Workflow, stage.JobID), name))
+	if err != nil {
+		t.Fatalf("stage %s does not have %s, error %v", stage.Name, name, err)
+	}
+	return string(data)
+}
+
+// TestChainOrder tests dependency order of chain stages
+func TestChainOrder(t *testing.T) {
+	stages := []ChainStage{
+		{Name: "strain", Depends: []string{"integrate"}},
+		{Name: "integrate", Depends: []string{"map", "calibrate"}},
+		{Name: "map"},
+		{Name: "calibrate"},
+	}
+	order, err := chainOrder(stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int)
+	for pos, i := range order {
+		position[stages[i].Name] = pos
+	}
+	for _, s := range stages {
+		for _, dep := range s.Depends {
+			if position[dep] > position[s.Name] {
+				t.Errorf("stage %s runs before its upstream stage %s", s.Name, dep)
+			}
+		}
+	}
+	down := chainDownstream(stages, "map")
+	if !down["map"] || !down["integrate"] || !down["strain"] || down["calibrate"] {
+		t.Errorf("wrong downstream stages %v", down)
+	}
+
+	// circular and unknown dependencies
+	stages[2].Depends = []string{"strain"}
+	if _, err := chainOrder(stages); err == nil {
+		t.Error("circular dependency is not detected")
+	}
+	stages[2].Depends = []string{"unknown"}
+	if _, err := chainOrder(stages); err == nil {
+		t.Error("unknown dependency is not detected")
+	}
+}
+
+// TestAdvanceChain tests stage transitions of workflow chain and staging of
+// upstream outputs
+func TestAdvanceChain(t *testing.T) {
+	setupChain(t)
+	chain := testChain("calibrate", "integrate")
+	if !advanceChain(&chain) || chain.Stages[0].Status != JobQueued || chain.Stages[1].Status != StagePending {
+		t.Fatalf("wrong stages after first step %+v", chain.Stages)
+	}
+	driveChain(t, &chain)
+	if chain.Status != JobFinished || chain.Stages[0].Status != JobFinished || chain.Stages[1].Status != JobFinished {
+		t.Fatalf("wrong chain %+v", chain)
+	}
+	// downstream stage gets outputs of upstream run listed in its manifest
+	if data := stageFile(t, chain.Stages[1], "inputs.txt"); data != "calibrate.out\n" {
+		t.Errorf("wrong inputs of downstream stage %q", data)
+	}
+	if data := stageFile(t, chain.Stages[1], "calibrate.out"); data != "output of calibrate\n" {
+		t.Errorf("wrong staged output %q", data)
+	}
+
+	// failed stage stops the chain and skips its downstream stages
+	chain = testChain("broken", "integrate")
+	driveChain(t, &chain)
+	if chain.Status != JobFailed || chain.Stages[0].Status != JobFailed {
+		t.Errorf("wrong failed chain %+v", chain)
+	}
+	if stage := chain.Stages[1]; stage.Status != StageSkipped || stage.JobID != "" {
+		t.Errorf("wrong downstream stage of failed stage %+v", stage)
+	}
+}
+
+// TestRerunChain tests rerun of workflow chain which reuses outputs of
+// upstream stage protected from removal
+func TestRerunChain(t *testing.T) {
+	setupChain(t)
+	Config.KeepRuns = 1
+	defer func() { Config.KeepRuns = 0 }()
+	chain := testChain("calibrate", "integrate")
+	driveChain(t, &chain)
+	if err := saveChain(chain); err != nil {
+		t.Fatal(err)
+	}
+	upstream := chain.Stages[0]
+
+	// newer run of upstream workflow does not prune run used by the chain
+	job := testJob("calibrate")
+	if err := jobManager.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, job.ID, 10*time.Second)
+	pruneRuns("test", "calibrate")
+	if err := deleteRun("test", upstream.JobID); err == nil {
+		t.Error("run used by workflow chain is deleted")
+	}
+	if _, err := getRun("test", upstream.JobID); err != nil {
+		t.Fatalf("run used by workflow chain is removed, error %v", err)
+	}
+
+	rerun, err := rerunChain("test", chain.ID, "integrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stage := rerun.Stages[0]; stage.Status != StageReused || stage.JobID != upstream.JobID {
+		t.Errorf("upstream stage is not reused %+v", stage)
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for !rerun.Done() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if rerun, err = getChain("test", rerun.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rerun.Status != JobFinished || rerun.Stages[1].JobID == chain.Stages[1].JobID {
+		t.Fatalf("wrong rerun chain %+v", rerun)
+	}
+	if data := stageFile(t, rerun.Stages[1], "calibrate.out"); data != "output of calibrate\n" {
+		t.Errorf("wrong output staged from reused stage %q", data)
+	}
+}
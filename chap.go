@@ -6,26 +6,12 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-func initWorkflowDir(user, workflow string) error {
-	path := fmt.Sprintf("%s/%s/%s", Config.UserDir, user, workflow)
-	err := os.RemoveAll(path)
-	if err != nil {
-		log.Println("os.RemoveAll", path, "error", err)
-		return err
-	}
-	err = os.MkdirAll(fmt.Sprintf("%s/%s/%s", Config.UserDir, user, workflow), 0755)
-	if err != nil {
-		log.Println("os..MkdirAll", path, "error", err)
-	}
-	return err
-}
 func initUserDir(user string) error {
 	// create and initialize user dir
 	err := os.MkdirAll(fmt.Sprintf("%s", Config.UserDir), 0755)
@@ -73,158 +59,148 @@ func addUserProcessor(user, module, processor string) {
 }
 */
 
-// helper function to run CHAP pipeline
-func runCHAP(user, config, workflow string, batch bool) ([]byte, error) {
-	var out []byte
-	var err error
-	fname := fmt.Sprintf("%s/%s/%s/run-chap.yaml", Config.UserDir, user, workflow)
+// helper function to write CHAP configuration of the job into its
+// directory, it returns name of configuration file
+func writeChapConfig(job Job) (string, error) {
+	fname := fmt.Sprintf("%s/run-chap.yaml", job.Dir)
 	if Config.Verbose > 0 {
 		log.Println("writing config file", fname)
 	}
 	if _, err := os.Stat(fname); errors.Is(err, os.ErrExist) {
 		err = os.Remove(fname)
 		if err != nil {
-			log.Println("runCHAP os.remove", err)
-			return out, err
+			log.Println("writeChapConfig os.remove", err)
+			return fname, err
 		}
 	}
 	file, err := os.Create(fname)
 	if err != nil {
-		log.Println("runCHAP os.create", err)
-		return out, err
+		log.Println("writeChapConfig os.create", err)
+		return fname, err
 	}
 	defer file.Close()
-	file.Write([]byte(config))
-
-	// run CHAP pipeline
-	cmd := fmt.Sprintf("%s/chap.sh", Config.ScriptsDir)
-	if batch {
-		cmd = fmt.Sprintf("%s/batch.sh", Config.ScriptsDir)
-	}
-	// user dir in configuration contains /users suffix
-	// but for running chap.sh script we should strip it off
-	//     userDir := strings.Replace(Config.UserDir, "/users", "", -1)
-	userDir := fmt.Sprintf("%s/%s", Config.UserDir, user)
-	wflowDir := fmt.Sprintf("%s/%s", Config.WorkflowsRoot, workflow)
-	log.Printf("### runCHAP: %s %s %s %s %s", cmd, workflow, fname, wflowDir, userDir)
-	out, err = exec.Command(cmd, workflow, fname, wflowDir, userDir).Output()
-	return out, err
+	_, err = file.Write([]byte(job.Config))
+	return fname, err
 }
 
-// helper function to generate user code
-func genUserCode(user, workflow, module, processor string, lines []string) {
+// userCodeHeader defines imports shared by all classes of user module
+const userCodeHeader = "from CHAP.pipeline import PipelineItem"
+
+// helper function to generate user code in given run directory, every user
+// reader, processor and writer of the code is generated as separate class of
+// user module
+func genUserCode(user, dir, module, processor string, lines []string) {
 
 	// initialize user dir
 	err := initUserDir(user)
 	if err != nil {
 		log.Println("ERROR: gen user code", err)
 	}
-	tmpl := make(TmplRecord)
+	code := parseUserCode(lines, processor)
 	var templates Templates
-	var content string
-	userCode := strings.Join(lines, "\n")
-	if strings.Contains(userCode, "class UserProcessor") {
-		content = userCode
-	} else {
+	content := []string{userCodeHeader}
+	if code.Module != "" {
+		content = append(content, code.Module)
+	}
+	for _, item := range code.Items {
+		tmpl := make(TmplRecord)
 		var newLines []string
-		for _, line := range lines {
+		for _, line := range item.Lines {
 			newLine := strings.Replace(line, "\n", "\n        ", -1)
 			newLines = append(newLines, newLine)
 		}
 		tmpl["Lines"] = newLines
-		tmpl["UserProcessor"] = processor
-		tfile := "processor.tmpl"
-		content = templates.TextTmpl(tfile, tmpl)
-	}
-	tdir := fmt.Sprintf("%s/%s/%s", Config.UserDir, user, workflow)
-	//     err = os.MkdirAll(fmt.Sprintf("%s/%s/%s", Config.UserDir, user, workflow), 0755)
-	initWorkflowDir(user, workflow)
-	if err != nil {
-		log.Println("genUserCode os.MkdirAll", err)
-	}
-	fname := fmt.Sprintf("%s/%s.py", tdir, module)
-	err = os.Remove(fname)
-	if err != nil {
-		log.Println("genUserCode os.remove", err)
+		tfile, name := itemTemplates[item.Kind][0], itemTemplates[item.Kind][1]
+		tmpl[name] = item.Name
+		content = append(content, templates.TextTmpl(tfile, tmpl))
 	}
+	fname := fmt.Sprintf("%s/%s.py", dir, module)
 	file, err := os.Create(fname)
 	if err != nil {
 		log.Println("genUserCode", err)
+		return
 	}
 	defer file.Close()
-	file.Write([]byte(content))
+	file.Write([]byte(strings.Join(content, "\n\n")))
 
 	// update user dir __init__.py file
 	//addUserProcessor(user, module, processor)
 }
 
-// helper function to generate CHAP config based on user workflow
-func genWorkflowConfig(user, module, workflow string) string {
-	var config string
-	for _, w := range chapWorkflows.getWorkflows() {
-		if strings.ToUpper(w.Name) == strings.ToUpper(workflow) {
-			fname := filepath.Join(w.Directory, w.Config)
-			file, err := os.Open(fname)
-			if err != nil {
-				log.Printf("ERROR: unable to locate %s", fname)
-				break
-			}
-			defer file.Close()
-			if body, err := io.ReadAll(file); err == nil {
-				config = string(body)
-				break
-			} else {
-				log.Printf("ERROR: unable to read %s, error %v", fname, err)
-			}
-		}
+// helper function to construct class path of user processor
+func userProcessorClass(user, module string) string {
+	return userClass(user, module, "UserProcessor")
+}
+
+// helper function to construct name of workflow configuration edited by the user
+func userConfigFile(user, workflow string) string {
+	return filepath.Join(Config.UserDir, user, workflow, "user-config.yaml")
+}
+
+// helper function to save workflow configuration edited by the user, the
+// configuration is validated against CHAP catalog and its warnings are
+// returned to the caller
+func saveUserConfig(user, workflow string, data []byte) ([]PipelineIssue, error) {
+	issues, err := validateConfig(string(data))
+	if err != nil {
+		return issues, err
 	}
-	if Config.Verbose > 0 {
-		log.Println("workflow config\n", config)
+	pipeline, err := parsePipeline(data)
+	if err != nil {
+		return issues, err
 	}
-	// TODO: properly handle yaml data by unmarshal it to pipeline
-	// struct and add new config entry to it, e.g.
-	/*
-		// convert config to yaml data
-		var pipeline map[string]interface{}
-		if err = yaml.Unmarshal(body, &p); err == nil {
-		}
-	*/
-	config += fmt.Sprintf("  - users.%s.%s.UserProcessor: {}\n", user, module)
-	return config
+	body, err := pipeline.Marshal()
+	if err != nil {
+		return issues, err
+	}
+	fname := userConfigFile(user, workflow)
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return issues, err
+	}
+	return issues, os.WriteFile(fname, body, 0644)
 }
 
-type Pipeline struct {
+// helper function to generate CHAP config based on user workflow with its
+// default user processor
+func genWorkflowConfig(user, module, workflow string) (string, error) {
+	return genUserConfig(user, module, workflow, parseUserCode(nil, "UserProcessor"))
 }
 
-// helper function to generate CHAP config based on given reader/writer
-func genChapConfig(user, module, reader, writer string) string {
-	config := "pipeline:\n"
-	reader = strings.ToLower(reader)
-	writer = strings.ToLower(writer)
-	if reader == "yaml" {
-		config += "  - common.YAMLReader: {}\n"
-	} else if reader == "nexus" {
-		config += "  - common.NexuReader: {}\n"
+// helper function to generate CHAP config based on user workflow and user
+// code, the configuration edited by the user has precedence over workflow
+// one and user readers, processors and writers are placed in the pipeline
+// unless it already refers to user classes
+func genUserConfig(user, module, workflow string, code UserCode) (string, error) {
+	fname := userConfigFile(user, workflow)
+	body, err := os.ReadFile(fname)
+	if err != nil {
+		if w, ok := findWorkflow(workflow); ok {
+			fname = filepath.Join(w.Directory, w.Config)
+			if body, err = os.ReadFile(fname); err != nil {
+				log.Printf("ERROR: unable to read %s, error %v", fname, err)
+			}
+		}
 	}
-	//config += "  - UserProcessor: {}\n  - common.PrintProcessor: {}"
-	config += fmt.Sprintf("  - users.%s.%s.UserProcessor: {}\n", user, module)
-	config += "  - common.PrintProcessor: {}\n"
-	if writer == "yaml" {
-		config += "  - common.YAMLWriter: {}\n"
-	} else if writer == "nexus" {
-		config += "  - common.NexuWriter: {}\n"
+	pipeline := newPipeline()
+	if len(body) > 0 {
+		if pipeline, err = parsePipeline(body); err != nil {
+			// pass configuration as is and let CHAP report its errors
+			log.Printf("ERROR: invalid CHAP configuration %s, error %v", fname, err)
+			return string(body), nil
+		}
+	}
+	if err := placeUserItems(pipeline, user, module, code); err != nil {
+		log.Printf("ERROR: unable to place user classes into CHAP configuration %s, error %v", fname, err)
 	}
-	/*
-	   	config := `
-	   pipeline:
-	     - UserProcessor: {}
-	     - common.PrintProcessor: {}
-	   `
-	*/
+	data, err := pipeline.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("unable to write CHAP configuration of workflow %s, error %v", workflow, err)
+	}
+	config := string(data)
 	if Config.Verbose > 0 {
-		log.Println("genChapConfig:\n", config)
+		log.Println("workflow config\n", config)
 	}
-	return config
+	return config, nil
 }
 
 // helper function to get CHAP workflows
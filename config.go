@@ -54,6 +54,7 @@ type Configuration struct {
 	ChapDir       string `json:"chap_dir"`       // CHAP install area
 	UserDir       string `json:"user_dir"`       // user directory
 	DocDir        string `json:"doc_dir"`        // CHAP doc directory
+	CatalogFile   string `json:"catalog_file"`   // CHAP catalog produced by scripts/catalog.py
 	UserRepo      string `json:"user_repo"`      // user repo to use, e.g. CHAPUsers
 	ScriptsDir    string `json:"scripts_dir"`    // scripts dir area
 	JupyterToken  string `json:"jupyter_token"`  // jupyter token
@@ -62,6 +63,42 @@ type Configuration struct {
 	WorkflowsRoot string `json:"workflows_root"` // workflows directory
 	GithubToken   string `json:"github_token"`   // github token to use for publication
 	DOI           string `json:"doi"`            // CHAPUsers/CHAPBook DOI reference
+
+	// job parts
+	JobWorkers   int    `json:"job_workers"`    // number of workers to run CHAP jobs
+	JobQueueSize int    `json:"job_queue_size"` // size of CHAP job queue
+	KeepRuns     int    `json:"keep_runs"`      // number of unpinned runs to keep per workflow, 0 keeps all
+	MaxSweepRuns int    `json:"max_sweep_runs"` // max number of runs of parameter sweep
+	JobTTL       string `json:"job_ttl"`        // how long finished jobs are kept in memory, e.g. 24h
+
+	// disk quota parts
+	Quota      string            `json:"quota"`       // default disk quota of user area, e.g. 50G
+	UserQuotas map[string]string `json:"user_quotas"` // quotas of individual users
+	Retention  string            `json:"retention"`   // retention period of tar-balls and unpinned runs, e.g. 720h
+	GCInterval string            `json:"gc_interval"` // interval of garbage collection, e.g. 1h
+
+	// notification parts
+	PublicURL       string     `json:"public_url"`       // public URL of the server used in notifications, e.g. https://chapaas.host
+	Webhooks        []Webhook  `json:"webhooks"`         // admin webhooks notified about all runs
+	WebhookAttempts int        `json:"webhook_attempts"` // number of webhook delivery attempts
+	SMTP            SMTPConfig `json:"smtp"`             // SMTP relay of email notifications
+
+	// default resource limits of CHAP jobs
+	Limits Limits `json:"limits"`
+
+	// executor parts
+	Executor      string `json:"executor"`       // default executor of CHAP jobs
+	BatchExecutor string `json:"batch_executor"` // executor of CHAP batch jobs
+	PollInterval  int    `json:"poll_interval"`  // interval in seconds to poll status of CHAP jobs
+
+	// batch parts
+	BatchQueue   string   `json:"batch_queue"`   // Grid Engine queue
+	BatchPE      string   `json:"batch_pe"`      // Grid Engine parallel environment of multi-cpu jobs
+	BatchOptions []string `json:"batch_options"` // additional Grid Engine options, e.g. "-l mem_free=8G"
+
+	// slurm parts
+	SlurmPartition string   `json:"slurm_partition"` // default Slurm partition
+	SlurmOptions   []string `json:"slurm_options"`   // additional sbatch options, e.g. "--account=chess"
 }
 
 // Credentials returns provider OAuth credential record
@@ -130,6 +167,71 @@ func parseConfig(configFile string) error {
 	if Config.ChapDir == "" {
 		log.Fatal("Empty ChapDir, please adjust your configuration")
 	}
+	if Config.JobWorkers == 0 {
+		Config.JobWorkers = 2
+	}
+	if Config.JobQueueSize == 0 {
+		Config.JobQueueSize = 100
+	}
+	if Config.JobTTL == "" {
+		Config.JobTTL = "24h"
+	}
+	if ttl, err := parseDuration(Config.JobTTL); err != nil || ttl <= 0 {
+		log.Fatalf("Invalid job_ttl '%s' in configuration, it should be positive duration", Config.JobTTL)
+	}
+	if Config.MaxSweepRuns == 0 {
+		Config.MaxSweepRuns = 100
+	}
+	if Config.Executor == "" {
+		Config.Executor = "local"
+	}
+	if Config.BatchExecutor == "" {
+		Config.BatchExecutor = "gridengine"
+	}
+	if Config.BatchQueue == "" {
+		Config.BatchQueue = "all.q"
+	}
+	if Config.BatchPE == "" {
+		Config.BatchPE = "smp"
+	}
+	if Config.BatchOptions == nil {
+		Config.BatchOptions = []string{"-l mem_free=8G"}
+	}
+	if Config.PollInterval == 0 {
+		Config.PollInterval = 1
+	}
+	if Config.GCInterval == "" {
+		Config.GCInterval = "1h"
+	}
+	if _, err := parseDuration(Config.Retention); err != nil {
+		log.Fatalf("Invalid garbage collection settings in configuration, error %v", err)
+	}
+	if interval, err := parseDuration(Config.GCInterval); err != nil || interval <= 0 {
+		log.Fatalf("Invalid gc_interval '%s' in configuration, it should be positive duration", Config.GCInterval)
+	}
+	if _, err := parseSize(Config.Quota); err != nil {
+		log.Fatalf("Invalid quota in configuration, error %v", err)
+	}
+	for user, quota := range Config.UserQuotas {
+		if _, err := parseSize(quota); err != nil {
+			log.Fatalf("Invalid quota of user %s in configuration, error %v", user, err)
+		}
+	}
+	if Config.WebhookAttempts == 0 {
+		Config.WebhookAttempts = 3
+	}
+	for i := range Config.Webhooks {
+		if err := Config.Webhooks[i].Validate(); err != nil {
+			log.Fatalf("Invalid webhook in configuration, error %v", err)
+		}
+		Config.Webhooks[i].ID = fmt.Sprintf("admin-%d", i+1)
+	}
+	if Config.SMTP.Enabled() && Config.SMTP.From == "" {
+		log.Fatal("Empty SMTP sender address, please adjust your configuration")
+	}
+	if err := Config.Limits.Validate(); err != nil {
+		log.Fatalf("Invalid limits in configuration, error %v", err)
+	}
 	if Config.RedirectURL == "" {
 		if host, err := os.Hostname(); err == nil {
 			Config.RedirectURL = fmt.Sprintf("http://%s:%d%s/github/callback", host, Config.Port, Config.Base)
@@ -0,0 +1,177 @@
+package main
+
+// email module provides email notifications about finished CHAP runs
+// delivered through SMTP relay
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// settingsBucket defines storage bucket of user settings
+const settingsBucket = "settings"
+
+// emailLogLines defines number of CHAP log lines included in email
+var emailLogLines = 50
+
+// SMTPConfig represents configuration of SMTP relay
+type SMTPConfig struct {
+	Host     string `json:"host"`     // SMTP host
+	Port     int    `json:"port"`     // SMTP port, default 25
+	Username string `json:"username"` // SMTP user name, empty for relay without authentication
+	Password string `json:"password"` // SMTP password
+	From     string `json:"from"`     // sender address of notifications
+}
+
+// Enabled returns true if SMTP relay is configured
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// UserSettings represents settings of the user
+type UserSettings struct {
+	User        string `json:"user"`         // user name
+	Email       string `json:"email"`        // email address of the user
+	NotifyBatch bool   `json:"notify_batch"` // notify about every finished batch run
+}
+
+// helper function to save settings of the user
+func saveSettings(s UserSettings) error {
+	if s.Email != "" {
+		addr, err := mail.ParseAddress(s.Email)
+		if err != nil {
+			return fmt.Errorf("invalid email address '%s', error %v", s.Email, err)
+		}
+		s.Email = addr.Address
+	}
+	if s.NotifyBatch && s.Email == "" {
+		return errors.New("please provide email address to receive notifications")
+	}
+	return storage.Put(s.User, s, settingsBucket)
+}
+
+// helper function to get settings of the user
+func getSettings(user string) UserSettings {
+	s := UserSettings{User: user}
+	if storage != nil {
+		storage.Get(user, &s, settingsBucket)
+	}
+	return s
+}
+
+// helper function to resolve email address to notify about the run, the
+// notify value may be either true or email address of user settings, while
+// empty value falls back to user settings of batch runs. We never send
+// notifications to arbitrary addresses provided with the request.
+func notifyAddress(user, notify string, batch bool) (string, error) {
+	s := getSettings(user)
+	if notify == "" || notify == "false" || notify == "0" {
+		if notify == "" && batch && s.NotifyBatch {
+			return s.Email, nil
+		}
+		return "", nil
+	}
+	if !Config.SMTP.Enabled() {
+		return "", errors.New("email notifications are not configured on this server")
+	}
+	if s.Email == "" {
+		return "", errors.New("please provide email address in your settings to receive notifications")
+	}
+	if notify == "true" || notify == "1" {
+		return s.Email, nil
+	}
+	addr, err := mail.ParseAddress(notify)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address '%s', error %v", notify, err)
+	}
+	if !strings.EqualFold(addr.Address, s.Email) {
+		return "", fmt.Errorf("email address '%s' does not match email of your settings", addr.Address)
+	}
+	return s.Email, nil
+}
+
+// helper function to obtain tail of CHAP log of the job
+func logTail(job Job, lines int) string {
+	content := strings.Split(strings.TrimRight(readJobLog(job), "\n"), "\n")
+	if len(content) > lines {
+		content = content[len(content)-lines:]
+	}
+	return strings.Join(content, "\n")
+}
+
+// helper function to compose notification email about finished job
+func runEmail(job Job) (string, string) {
+	subject := fmt.Sprintf("[CHAP] run %s of workflow %s is %s", job.ID, job.Workflow, job.Status)
+	var body strings.Builder
+	fmt.Fprintf(&body, "Dear %s,\n\n", job.User)
+	fmt.Fprintf(&body, "your CHAP run %s of workflow %s is %s.\n\n", job.ID, job.Workflow, job.Status)
+	fmt.Fprintf(&body, "Executor: %s %s\n", job.Executor, job.ExecID)
+	fmt.Fprintf(&body, "Exit code: %d\n", job.ExitCode)
+	fmt.Fprintf(&body, "Duration: %v\n", job.Duration().Round(time.Second))
+	if job.Reason != "" {
+		fmt.Fprintf(&body, "Reason: %s\n", job.Reason)
+	}
+	if job.Error != "" {
+		fmt.Fprintf(&body, "Error: %s\n", job.Error)
+	}
+	fmt.Fprintf(&body, "Job: %s\n", publicURL(fmt.Sprintf("%s/chap/jobs/%s", Config.Base, job.ID)))
+	fmt.Fprintf(&body, "Run area: %s/\n", publicURL(runURL(job.User, job.Workflow, job.ID)))
+	if len(job.Outputs) > 0 {
+		fmt.Fprintf(&body, "\nOutputs:\n")
+		for _, path := range job.Outputs {
+			fmt.Fprintf(&body, "  %s\n", publicURL(path))
+		}
+	}
+	if tail := logTail(job, emailLogLines); tail != "" {
+		fmt.Fprintf(&body, "\nLast %d lines of CHAP log:\n\n%s\n", emailLogLines, tail)
+	}
+	return subject, body.String()
+}
+
+// helper function to send email through configured SMTP relay
+func sendEmail(to, subject, body string) error {
+	cfg := Config.SMTP
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := fmt.Sprintf("%s:%d", cfg.Host, port)
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(addr, auth, cfg.From, []string{to}, []byte(msg.String()))
+}
+
+// helper function to notify user by email about finished job, the
+// cancelled jobs are not notified
+func notifyEmail(job Job) {
+	if job.Notify == "" || !Config.SMTP.Enabled() {
+		return
+	}
+	if job.Status != JobFinished && job.Status != JobFailed {
+		return
+	}
+	subject, body := runEmail(job)
+	if err := sendEmail(job.Notify, subject, body); err != nil {
+		log.Printf("ERROR: unable to send email about job %s to %s, error %v", job.ID, job.Notify, err)
+		return
+	}
+	log.Printf("job %s notification is sent to %s", job.ID, job.Notify)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// helper function to start local SMTP sink which stores received message
+func smtpSink(t *testing.T, messages chan string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost SMTP sink\r\n")
+		var data strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case cmd == "DATA":
+				fmt.Fprint(conn, "354 end data with <CR><LF>.<CR><LF>\r\n")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				messages <- data.String()
+				fmt.Fprint(conn, "250 OK\r\n")
+			case cmd == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestNotifyEmail tests email notification about finished job
+func TestNotifyEmail(t *testing.T) {
+	messages := make(chan string, 1)
+	host, port, _ := net.SplitHostPort(smtpSink(t, messages))
+	Config.SMTP = SMTPConfig{Host: host, From: "chapaas@localhost"}
+	fmt.Sscanf(port, "%d", &Config.SMTP.Port)
+	defer func() { Config.SMTP = SMTPConfig{} }()
+
+	dir := t.TempDir()
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	os.WriteFile(filepath.Join(dir, "chap.log"), []byte(strings.Join(lines, "\n")), 0644)
+	job := Job{
+		ID:       "123",
+		User:     "user",
+		Workflow: "saxswaxs",
+		Status:   JobFailed,
+		ExitCode: 1,
+		Dir:      dir,
+		Notify:   "user@localhost",
+		Outputs:  []string{"/users/user/saxswaxs/runs/123/chap.log"},
+	}
+	notifyEmail(job)
+	msg := <-messages
+	for _, s := range []string{"To: user@localhost", "is failed", "line 99", "/users/user/saxswaxs/runs/123/chap.log"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("email does not contain '%s'", s)
+		}
+	}
+	if strings.Contains(msg, "line 49\r\n") {
+		t.Error("email should contain only tail of the log")
+	}
+}
+
+// TestNotifyAddress tests that notifications are sent only to email address
+// of user settings
+func TestNotifyAddress(t *testing.T) {
+	setupStorage(t)
+	Config.SMTP = SMTPConfig{Host: "localhost", From: "chapaas@localhost"}
+	defer func() { Config.SMTP = SMTPConfig{} }()
+	if _, err := notifyAddress("user", "true", false); err == nil {
+		t.Error("notification without email in settings is accepted")
+	}
+	settings := UserSettings{User: "user", Email: "User@Host.org", NotifyBatch: true}
+	if err := storage.Put("user", settings, settingsBucket); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []struct {
+		notify string
+		batch  bool
+		addr   string
+		fail   bool
+	}{
+		{"true", false, "User@Host.org", false},
+		{"user@host.org", false, "User@Host.org", false},
+		{"", true, "User@Host.org", false},
+		{"", false, "", false},
+		{"false", true, "", false},
+		{"victim@other.org", false, "", true},
+		{"User <victim@other.org>", false, "", true},
+		{"not an address", false, "", true},
+	} {
+		addr, err := notifyAddress("user", rec.notify, rec.batch)
+		if (err != nil) != rec.fail || addr != rec.addr {
+			t.Errorf("notify '%s': wrong address '%s', error %v", rec.notify, addr, err)
+		}
+	}
+}
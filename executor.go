@@ -0,0 +1,200 @@
+package main
+
+// executor module provides different backends to execute CHAP pipelines
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecStatus represents status of the job reported by executor
+type ExecStatus struct {
+	Status   string // job status, see Job* constants
+	ExitCode int    // exit code of CHAP pipeline
+	Error    string // error message
+	Info     string // executor specific status information
+}
+
+// Executor defines interface of CHAP pipeline backends
+type Executor interface {
+	Submit(job Job) (string, error)      // submit job and return its executor id
+	Status(job Job) (ExecStatus, error)  // return status of the job
+	Cancel(job Job) error                // cancel the job
+	Logs(job Job) (io.ReadCloser, error) // return reader of job logs
+}
+
+// executors holds all known executors keyed by their names
+var executors = make(map[string]Executor)
+
+// helper function to initialize supported executors
+func initExecutors() {
+	executors["local"] = NewLocalExecutor(fmt.Sprintf("%s/chap.sh", Config.ScriptsDir))
+	executors["gridengine"] = NewGridEngineExecutor(Config.BatchQueue, Config.BatchPE, Config.BatchOptions)
+	executors["slurm"] = NewSlurmExecutor(Config.SlurmPartition, Config.SlurmOptions)
+	log.Println("CHAP executors", executorNames())
+}
+
+// helper function to return names of known executors
+func executorNames() []string {
+	var names []string
+	for name := range executors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function to get executor for given name
+func getExecutor(name string) (Executor, error) {
+	if e, ok := executors[name]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown executor '%s', supported executors: %s", name, strings.Join(executorNames(), ", "))
+}
+
+// helper function to select executor name, the explicit request has
+// precedence over workflow configuration, otherwise we use server default
+func selectExecutor(request, workflow string) string {
+	if request != "" {
+		return request
+	}
+	if w, ok := findWorkflow(workflow); ok && w.Executor != "" {
+		return w.Executor
+	}
+	return Config.Executor
+}
+
+// helper function to open CHAP log of the job
+func openJobLog(job Job) (io.ReadCloser, error) {
+	return os.Open(fmt.Sprintf("%s/chap.log", job.Dir))
+}
+
+// localProcess represents CHAP process started by local executor
+type localProcess struct {
+	cmd  *exec.Cmd     // CHAP command
+	done chan struct{} // closed when command is finished
+	err  error         // command error
+}
+
+// LocalExecutor runs CHAP pipeline as local process via given script
+type LocalExecutor struct {
+	sync.RWMutex
+	Script    string                   // script to run CHAP pipeline
+	processes map[string]*localProcess // running processes keyed by job id
+}
+
+// NewLocalExecutor creates new local executor for given script
+func NewLocalExecutor(script string) *LocalExecutor {
+	return &LocalExecutor{
+		Script:    script,
+		processes: make(map[string]*localProcess),
+	}
+}
+
+// Submit starts CHAP pipeline process for given job
+func (e *LocalExecutor) Submit(job Job) (string, error) {
+	fname, err := writeChapConfig(job)
+	if err != nil {
+		return "", err
+	}
+	wflowDir := fmt.Sprintf("%s/%s", Config.WorkflowsRoot, job.Workflow)
+	log.Printf("### runCHAP: %s %s %s %s %s", e.Script, job.Workflow, fname, wflowDir, job.Dir)
+	cmd := exec.Command(e.Script, job.Workflow, fname, wflowDir, job.Dir)
+	if ulimit := ulimitCommand(job.Limits); ulimit != "" {
+		// apply resource limits to the script and all its children
+		script := fmt.Sprintf("%s && exec \"$0\" \"$@\"", ulimit)
+		cmd = exec.Command("/bin/bash", "-c", script, e.Script, job.Workflow, fname, wflowDir, job.Dir)
+	}
+	if job.Profile {
+		cmd.Env = append(os.Environ(), "CHAP_PROFILE=1")
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	proc := &localProcess{cmd: cmd, done: make(chan struct{})}
+	e.Lock()
+	e.processes[job.ID] = proc
+	e.Unlock()
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+	return fmt.Sprintf("%d", cmd.Process.Pid), nil
+}
+
+// Status returns status of local CHAP process
+func (e *LocalExecutor) Status(job Job) (ExecStatus, error) {
+	e.RLock()
+	proc, ok := e.processes[job.ID]
+	e.RUnlock()
+	if !ok {
+		return ExecStatus{}, fmt.Errorf("unable to find process of job %s", job.ID)
+	}
+	select {
+	case <-proc.done:
+	default:
+		return ExecStatus{Status: JobRunning}, nil
+	}
+	// process is finished and we no longer need to track it
+	e.Lock()
+	delete(e.processes, job.ID)
+	e.Unlock()
+	if proc.err != nil {
+		return ExecStatus{Status: JobFailed, ExitCode: exitCode(proc.err), Error: proc.err.Error()}, nil
+	}
+	return ExecStatus{Status: JobFinished}, nil
+}
+
+// Cancel terminates process group of CHAP process
+func (e *LocalExecutor) Cancel(job Job) error {
+	e.RLock()
+	proc, ok := e.processes[job.ID]
+	e.RUnlock()
+	if !ok {
+		return errors.New("job process is not found")
+	}
+	return stopCommand(proc.cmd, proc.done)
+}
+
+// Logs returns CHAP log of the job
+func (e *LocalExecutor) Logs(job Job) (io.ReadCloser, error) {
+	return openJobLog(job)
+}
+
+// helper function to stop command, it terminates process group of the
+// command and kill it if it is still alive after grace period, the done
+// channel is closed when command is finished
+func stopCommand(cmd *exec.Cmd, done <-chan struct{}) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	if err := terminateProcessGroup(cmd); err != nil {
+		log.Println("ERROR: unable to terminate process group", err)
+		return killProcessGroup(cmd)
+	}
+	// kill remaining processes of the group after grace period unless
+	// the command is already finished, otherwise its process group id
+	// may be reused by unrelated processes
+	timer := time.NewTimer(cancelGracePeriod)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+			killProcessGroup(cmd)
+		}
+	}()
+	return nil
+}
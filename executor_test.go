@@ -0,0 +1,153 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper function to submit test job to local executor of given script
+func submitLocal(t *testing.T, script string) (*LocalExecutor, Job) {
+	Config.WorkflowsRoot = t.TempDir()
+	defer func() { Config.WorkflowsRoot = "" }()
+	e := NewLocalExecutor(script)
+	job := *testJob("wflow")
+	job.Dir = t.TempDir()
+	if _, err := e.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	return e, job
+}
+
+// helper function to wait for local process of the job to finish
+func waitLocal(t *testing.T, e *LocalExecutor, job Job) ExecStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := e.Status(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != JobRunning {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("process of job %s is still running", job.ID)
+	return ExecStatus{}
+}
+
+// helper function to wait for file created by the process
+func waitFile(t *testing.T, fname string) string {
+	for i := 0; i < 200; i++ {
+		if data, err := os.ReadFile(fname); err == nil && strings.HasSuffix(string(data), "\n") {
+			return strings.TrimSpace(string(data))
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatalf("file %s is not created", fname)
+	return ""
+}
+
+// helper function to check if process is alive, zombie processes are
+// considered to be dead
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[strings.LastIndex(string(data), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// TestLocalExecutor tests status and exit code of local CHAP process
+func TestLocalExecutor(t *testing.T) {
+	e, job := submitLocal(t, fakeChap(t, `echo "CHAP $1" > chap.log; exit 2`))
+	status := waitLocal(t, e, job)
+	if status.Status != JobFailed || status.ExitCode != 2 {
+		t.Errorf("wrong status %+v", status)
+	}
+	// finished process is no longer tracked
+	if _, err := e.Status(job); err == nil {
+		t.Error("finished process is still tracked")
+	}
+	if err := e.Cancel(job); err == nil {
+		t.Error("finished process is cancelled")
+	}
+	reader, err := e.Logs(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	buf := make([]byte, 64)
+	n, _ := reader.Read(buf)
+	if string(buf[:n]) != "CHAP wflow\n" {
+		t.Errorf("wrong log %q", buf[:n])
+	}
+}
+
+// TestLocalExecutorCancel tests that cancel terminates CHAP process along
+// with its children
+func TestLocalExecutorCancel(t *testing.T) {
+	e, job := submitLocal(t, fakeChap(t, `sleep 30 &
+echo $! > child.pid
+wait`))
+	pid, err := strconv.Atoi(waitFile(t, filepath.Join(job.Dir, "child.pid")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := e.Status(job); err != nil || status.Status != JobRunning {
+		t.Fatalf("wrong status %+v, error %v", status, err)
+	}
+	if err := e.Cancel(job); err != nil {
+		t.Fatal(err)
+	}
+	status := waitLocal(t, e, job)
+	if status.Status != JobFailed || status.ExitCode != 143 {
+		t.Errorf("wrong status %+v", status)
+	}
+	for i := 0; i < 100 && processAlive(pid); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		t.Errorf("child process %d is still alive", pid)
+	}
+}
+
+// TestLocalExecutorKill tests that process which ignores termination signal
+// is killed after grace period
+func TestLocalExecutorKill(t *testing.T) {
+	period := cancelGracePeriod
+	cancelGracePeriod = 200 * time.Millisecond
+	defer func() { cancelGracePeriod = period }()
+	e, job := submitLocal(t, fakeChap(t, `trap "" TERM
+echo started > started
+sleep 30`))
+	waitFile(t, filepath.Join(job.Dir, "started"))
+	if err := e.Cancel(job); err != nil {
+		t.Fatal(err)
+	}
+	status := waitLocal(t, e, job)
+	if status.Status != JobFailed || status.ExitCode != 137 {
+		t.Errorf("wrong status %+v", status)
+	}
+}
+
+// TestSelectExecutor tests selection of executor of the job
+func TestSelectExecutor(t *testing.T) {
+	Config.Executor = "local"
+	defer func() { Config.Executor = "" }()
+	if name := selectExecutor("slurm", "unknown"); name != "slurm" {
+		t.Errorf("requested executor is not selected, got %s", name)
+	}
+	if name := selectExecutor("", "unknown"); name != "local" {
+		t.Errorf("default executor is not selected, got %s", name)
+	}
+	if _, err := getExecutor("unknown"); err == nil {
+		t.Error("unknown executor is found")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+// gridengine module provides Grid Engine executor of CHAP pipelines
+// for more info see: https://wiki.classe.cornell.edu/Computing/GridEngine
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// qsubPattern matches output of qsub command, e.g.
+// Your job 12345 ("batch_submit.sh") has been submitted
+var qsubPattern = regexp.MustCompile(`Your job(-array)? ([0-9]+)`)
+
+// GridEngineExecutor submits CHAP pipeline to Grid Engine batch system
+type GridEngineExecutor struct {
+	Queue       string       // default batch queue to use
+	ParallelEnv string       // parallel environment of multi-cpu jobs
+	Options     []string     // additional qsub options
+	acct        *acctTracker // tracker of finished jobs without accounting records
+}
+
+// NewGridEngineExecutor creates new Grid Engine executor
+func NewGridEngineExecutor(queue, pe string, options []string) *GridEngineExecutor {
+	return &GridEngineExecutor{
+		Queue:       queue,
+		ParallelEnv: pe,
+		Options:     options,
+		acct:        newAcctTracker(5 * time.Minute),
+	}
+}
+
+// Submit writes batch submit script into job directory and submits it via qsub
+func (e *GridEngineExecutor) Submit(job Job) (string, error) {
+	// workflow may request its own resources, its partition is used as
+	// Grid Engine queue, otherwise we use defaults
+	var resources Resources
+	if w, ok := findWorkflow(job.Workflow); ok {
+		resources = w.Resources
+	}
+	if resources.Partition == "" {
+		resources.Partition = e.Queue
+	}
+	if timeout := job.Limits.TimeoutDuration(); resources.Walltime == "" && timeout > 0 {
+		resources.Walltime = batchDuration(timeout)
+	}
+	script, err := prepareBatchJob(job, "gridengine.tmpl", func(tmpl TmplRecord) {
+		tmpl["Resources"] = resources
+		tmpl["ParallelEnv"] = e.ParallelEnv
+		tmpl["Options"] = e.Options
+	})
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("qsub", script)
+	cmd.Dir = job.Dir
+	out, err := cmd.CombinedOutput()
+	if Config.Verbose > 0 {
+		log.Printf("qsub %s output %s error %v", script, string(out), err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("qsub failed with error %v, output: %s", err, string(out))
+	}
+	return parseQsubOutput(out)
+}
+
+// helper function to parse job id from qsub output, we support both
+// standard and terse outputs
+func parseQsubOutput(out []byte) (string, error) {
+	if matches := qsubPattern.FindSubmatch(out); len(matches) == 3 {
+		return string(matches[2]), nil
+	}
+	id := strings.TrimSpace(string(out))
+	if _, err := strconv.Atoi(strings.Split(id, ".")[0]); err == nil {
+		return strings.Split(id, ".")[0], nil
+	}
+	return "", fmt.Errorf("unable to parse qsub output: %s", string(out))
+}
+
+// Status returns status of the job using qstat for active jobs and qacct
+// for finished ones
+func (e *GridEngineExecutor) Status(job Job) (ExecStatus, error) {
+	out, err := exec.Command("qstat").Output()
+	if err != nil {
+		return ExecStatus{}, fmt.Errorf("qstat failed with error %v", err)
+	}
+	if state, ok := parseQstatOutput(out, job.ExecID); ok {
+		status := ExecStatus{Status: JobRunning, Info: state}
+		if strings.Contains(state, "E") {
+			// the job in error state stays in the queue until it is
+			// deleted, we remove it since job is reported as failed
+			status.Status = JobFailed
+			status.Error = fmt.Sprintf("grid engine job %s is in error state %s", job.ExecID, state)
+			if err := e.Cancel(job); err != nil {
+				log.Printf("ERROR: unable to remove grid engine job %s in error state, error %v", job.ExecID, err)
+			}
+		} else if strings.Contains(state, "qw") || strings.Contains(state, "h") {
+			status.Status = JobQueued
+		}
+		return status, nil
+	}
+
+	// job is no longer known to qstat, get its accounting record
+	out, err = exec.Command("qacct", "-j", job.ExecID).Output()
+	if err == nil {
+		e.acct.forget(job.ExecID)
+		return parseQacctOutput(out)
+	}
+	return e.acct.wait(job.ExecID), nil
+}
+
+// helper function to find state of the job in qstat output, e.g.
+// job-ID prior   name       user         state submit/start at     queue  slots ja-task-ID
+// ---------------------------------------------------------------------------------------
+// 12345 0.55500 chap-job   user         r     05/15/2023 10:00:00 all.q@node 1
+func parseQstatOutput(out []byte, id string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[0] == id {
+			return fields[4], true
+		}
+	}
+	return "", false
+}
+
+// helper function to parse qacct output and obtain exit status of the job
+func parseQacctOutput(out []byte) (ExecStatus, error) {
+	status := ExecStatus{Status: JobFinished}
+	var found bool
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "failed":
+			if fields[1] != "0" {
+				status.Status = JobFailed
+				status.Error = fmt.Sprintf("grid engine failure: %s", strings.Join(fields[1:], " "))
+			}
+		case "exit_status":
+			code, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return status, fmt.Errorf("unable to parse exit_status of qacct output, error %v", err)
+			}
+			found = true
+			status.ExitCode = code
+			if code != 0 {
+				status.Status = JobFailed
+				if status.Error == "" {
+					status.Error = fmt.Sprintf("exit status %d", code)
+				}
+			}
+		}
+	}
+	if !found {
+		return status, errors.New("qacct output does not contain exit_status")
+	}
+	return status, nil
+}
+
+// Cancel removes the job from Grid Engine
+func (e *GridEngineExecutor) Cancel(job Job) error {
+	out, err := exec.Command("qdel", job.ExecID).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("qdel failed with error %v, output: %s", err, string(out))
+	}
+	return nil
+}
+
+// Logs returns CHAP log of the job
+func (e *GridEngineExecutor) Logs(job Job) (io.ReadCloser, error) {
+	return openJobLog(job)
+}
+
+// acctTracker keeps track of batch jobs which are no longer known to the
+// batch system queue but do not have yet accounting records
+type acctTracker struct {
+	sync.Mutex
+	Timeout  time.Duration        // how long we wait for accounting record of finished job
+	finished map[string]time.Time // time when job disappeared from the queue
+}
+
+// helper function to create new accounting tracker
+func newAcctTracker(timeout time.Duration) *acctTracker {
+	return &acctTracker{Timeout: timeout, finished: make(map[string]time.Time)}
+}
+
+// helper function to provide status of the job without accounting record,
+// the job is considered as running until timeout is reached
+func (a *acctTracker) wait(id string) ExecStatus {
+	a.Lock()
+	defer a.Unlock()
+	since, ok := a.finished[id]
+	if !ok {
+		since = time.Now()
+		a.finished[id] = since
+	}
+	if time.Since(since) > a.Timeout {
+		delete(a.finished, id)
+		msg := fmt.Sprintf("unable to obtain accounting record of batch job %s", id)
+		return ExecStatus{Status: JobFailed, ExitCode: -1, Error: msg}
+	}
+	return ExecStatus{Status: JobRunning, Info: "finishing"}
+}
+
+// helper function to forget about finished job
+func (a *acctTracker) forget(id string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.finished, id)
+}
+
+// helper function to prepare job directory for batch submission, it copies
+// workflow files into job directory, writes CHAP configuration and batch
+// script from given template. It returns name of the batch script.
+func prepareBatchJob(job Job, tfile string, update func(tmpl TmplRecord)) (string, error) {
+	wflowDir := fmt.Sprintf("%s/%s", Config.WorkflowsRoot, job.Workflow)
+	if err := copyDir(wflowDir, job.Dir); err != nil {
+		return "", err
+	}
+	config, err := writeChapConfig(job)
+	if err != nil {
+		return "", err
+	}
+	env, err := condaEnv(wflowDir)
+	if err != nil {
+		return "", err
+	}
+	tmpl := make(TmplRecord)
+	tmpl["Name"] = fmt.Sprintf("chap-%s", job.ID)
+	tmpl["Dir"] = job.Dir
+	tmpl["Config"] = config
+	tmpl["CondaEnv"] = env
+	tmpl["Ulimit"] = ulimitCommand(job.Limits)
+	tmpl["Profile"] = job.Profile
+	tmpl["ProfileScript"] = profileScript()
+	if update != nil {
+		update(tmpl)
+	}
+	var templates Templates
+	content := templates.TextTmpl(tfile, tmpl)
+	fname := filepath.Join(job.Dir, "batch_submit.sh")
+	if err := os.WriteFile(fname, []byte(content), 0755); err != nil {
+		return "", err
+	}
+	return fname, nil
+}
+
+// helper function to obtain conda environment name of the workflow
+func condaEnv(wflowDir string) (string, error) {
+	fname := filepath.Join(wflowDir, "conda.yml")
+	file, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "name:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "name:")), nil
+		}
+	}
+	return "", fmt.Errorf("unable to find conda environment name in %s", fname)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// helper function to create fake batch command in given directory
+func fakeCommand(t *testing.T, dir, name, script string) {
+	fname := filepath.Join(dir, name)
+	content := fmt.Sprintf("#!/bin/bash\n%s\n", script)
+	if err := os.WriteFile(fname, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGridEngineExecutor tests Grid Engine executor with fake qsub/qstat/qacct commands
+func TestGridEngineExecutor(t *testing.T) {
+	bin := t.TempDir()
+	state := filepath.Join(bin, "state")
+	fakeCommand(t, bin, "qsub", `echo "Your job 4242 (\"$1\") has been submitted"`)
+	fakeCommand(t, bin, "qstat", fmt.Sprintf(`if [ -f %s ]; then
+echo "job-ID prior name user state submit/start at queue slots"
+echo "4242 0.555 chap user $(cat %s) 05/15/2023 10:00:00 all.q 1"
+fi`, state, state))
+	fakeCommand(t, bin, "qacct", "echo \"jobnumber 4242\"\necho \"failed 0\"\necho \"exit_status 3\"")
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	// setup workflow with its resources and job areas
+	Config.WorkflowsRoot = t.TempDir()
+	wdir := filepath.Join(Config.WorkflowsRoot, "saxswaxs")
+	os.MkdirAll(wdir, 0755)
+	os.WriteFile(filepath.Join(wdir, "conda.yml"), []byte("name: saxswaxs\n"), 0644)
+	spec := "name: saxswaxs\nconfig: pipeline.yaml\nresources:\n  memory: 16G\n  cpus: 4\n  walltime: \"01:00:00\"\n"
+	os.WriteFile(filepath.Join(wdir, "chap.yaml"), []byte(spec), 0644)
+	job := Job{ID: "test", Workflow: "saxswaxs", Dir: t.TempDir(), Config: "pipeline: []\n"}
+
+	executor := NewGridEngineExecutor("all.q", "smp", []string{"-l mem_free=8G"})
+	id, err := executor.Submit(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "4242" {
+		t.Fatalf("wrong job id %s", id)
+	}
+	script, err := os.ReadFile(filepath.Join(job.Dir, "batch_submit.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"#$ -q all.q", "#$ -l mem_free=16G", "#$ -pe smp 4", "#$ -l h_rt=01:00:00", "conda activate saxswaxs"} {
+		if !strings.Contains(string(script), line) {
+			t.Errorf("batch script does not contain '%s'", line)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(job.Dir, "conda.yml")); err != nil {
+		t.Error("workflow files are not copied to job directory")
+	}
+
+	job.ExecID = id
+	for _, rec := range []struct {
+		state  string
+		status string
+	}{{"qw", JobQueued}, {"r", JobRunning}, {"", JobFailed}} {
+		os.Remove(state)
+		if rec.state != "" {
+			os.WriteFile(state, []byte(rec.state), 0644)
+		}
+		status, err := executor.Status(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != rec.status {
+			t.Errorf("state %s: wrong status %s, expect %s", rec.state, status.Status, rec.status)
+		}
+	}
+	status, _ := executor.Status(job)
+	if status.ExitCode != 3 {
+		t.Errorf("wrong exit code %d", status.ExitCode)
+	}
+
+	// job in error state is failed and removed from the queue
+	deleted := filepath.Join(bin, "deleted")
+	fakeCommand(t, bin, "qdel", fmt.Sprintf(`echo $1 > %s`, deleted))
+	os.WriteFile(state, []byte("Eqw"), 0644)
+	status, err = executor.Status(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != JobFailed || !strings.Contains(status.Error, "error state Eqw") {
+		t.Errorf("wrong status of job in error state %+v", status)
+	}
+	if data, err := os.ReadFile(deleted); err != nil || strings.TrimSpace(string(data)) != "4242" {
+		t.Errorf("job in error state is not deleted, error %v", err)
+	}
+}
@@ -6,6 +6,7 @@ package main
 //
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -114,6 +115,58 @@ func httpError(w http.ResponseWriter, r *http.Request, tmpl TmplRecord, code int
 	httpResponse(w, r, tmpl)
 }
 
+// helper function to reply with error in JSON or HTML format requested by
+// the client
+func replyError(w http.ResponseWriter, r *http.Request, tmpl TmplRecord, httpCode int, err error) {
+	if acceptJSON(r) {
+		writeJSON(w, map[string]string{"error": err.Error()}, httpCode)
+		return
+	}
+	code := BadRequest
+	if httpCode == http.StatusInternalServerError {
+		code = GenericError
+	}
+	httpError(w, r, tmpl, code, err, httpCode)
+}
+
+// helper function to get HTTP status code of job submission error, the
+// errors of unknown kind get given default code
+func submitErrorCode(err error, code int) int {
+	switch {
+	case errors.Is(err, ErrInvalidRun):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrActiveJob):
+		return http.StatusConflict
+	case errors.Is(err, ErrQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	return code
+}
+
+// helper function to check if client asks for JSON response either via
+// HTTP Accept header or format=json query parameter
+func acceptJSON(r *http.Request) bool {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		return true
+	}
+	return r.URL.Query().Get("format") == "json"
+}
+
+// helper function to write JSON response
+func writeJSON(w http.ResponseWriter, rec any, httpCode int) {
+	data, err := json.MarshalIndent(rec, "", "    ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorMessage(JsonMarshal)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	w.Write(data)
+}
+
 // helper function to make initial template struct
 func makeTmpl(title string) TmplRecord {
 	tmpl := make(TmplRecord)
@@ -389,7 +442,14 @@ func ChapWorkflowHandler(w http.ResponseWriter, r *http.Request) {
 	params := bunrouter.ParamsFromContext(r.Context())
 	workflow := params.ByName("workflow")
 	module := "userprocessor" // it is irrelevant in this case
-	config := genWorkflowConfig(user, module, workflow)
+	config, err := genWorkflowConfig(user, module, workflow)
+	if err != nil {
+		log.Printf("ERROR: unable to generate config of workflow %s, error %v", workflow, err)
+		tmpl["Error"] = err
+		tmpl["HttpCode"] = http.StatusInternalServerError
+		httpResponse(w, r, tmpl)
+		return
+	}
 	tmpl["Config"] = config
 	tmpl["Workflow"] = workflow
 	tmpl["Template"] = "workflow_config.tmpl"
@@ -416,21 +476,211 @@ func ChapConfigHandler(w http.ResponseWriter, r *http.Request) {
 		if Config.Verbose > 0 {
 			log.Printf("### POST record=%+v error=%v", string(data), err)
 		}
-		// write provided config content back to user's area into chap.yaml file
-		fname := fmt.Sprintf("%s/users/%s/%s/chap.yaml", Config.UserDir, user, workflow)
-		file, err := os.Open(fname)
+		// write provided config content back to user's area, it will be
+		// used by subsequent runs of the workflow
+		issues, err := saveUserConfig(user, workflow, data)
+		if acceptJSON(r) {
+			rec := make(map[string]any)
+			rec["issues"] = issues
+			code := http.StatusOK
+			if err != nil {
+				rec["error"] = err.Error()
+				code = http.StatusBadRequest
+			}
+			writeJSON(w, rec, code)
+			return
+		}
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("ERROR: unable to save config of workflow %s, error %v", workflow, err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		msg := "success"
+		for _, issue := range issues {
+			msg += "\n" + issue.String()
 		}
-		defer file.Close()
-		file.Write(data)
-		w.Write([]byte("success"))
+		w.Write([]byte(msg))
+		return
+	}
+	module, _ := userModule(r)
+	config, err := genWorkflowConfig(user, module, workflow)
+	if err != nil {
+		log.Printf("ERROR: unable to generate config of workflow %s, error %v", workflow, err)
+		if acceptJSON(r) {
+			writeJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if acceptJSON(r) {
+		// provide pipeline items for pipeline composer
+		pipeline, err := parsePipeline([]byte(config))
+		if err != nil {
+			writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		rec := make(map[string]any)
+		rec["workflow"] = workflow
+		rec["items"] = pipeline.Items()
+		rec["config"] = config
+		writeJSON(w, rec, http.StatusOK)
+		return
 	}
-	module := "userprocessor" // it is irrelevant in this case
-	config := genWorkflowConfig(user, module, workflow)
 	w.Write([]byte(config))
 }
 
+// ChapCatalogHandler provides catalog of CHAP readers, writers and processors
+func ChapCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := getUser(r); err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, getCatalog().List(), http.StatusOK)
+}
+
+// ChapProcessorsHandler provides class paths and kinds of user readers,
+// processors and writers defined in user's notebooks
+func ChapProcessorsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getUser(r)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	lines, err := requestUserCode(r, user)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	module, processor := userModule(r)
+	code := parseUserCode(lines, processor)
+	var records []map[string]string
+	for _, name := range code.Classes {
+		rec := map[string]string{"name": name, "kind": code.Kinds[name], "class": userClass(user, module, name)}
+		records = append(records, rec)
+	}
+	writeJSON(w, records, http.StatusOK)
+}
+
+// ChapPipelineHandler builds CHAP pipeline from reader, processors and
+// writer provided in JSON body, the pipeline is saved as configuration of
+// user's workflow when request provides the workflow
+func ChapPipelineHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getUser(r)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var req PipelineRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		err = fmt.Errorf("unable to parse pipeline request, error %v", err)
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	module, _ := userModule(r)
+	pipeline, err := buildPipeline(user, module, req)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	data, err := pipeline.Marshal()
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	config := string(data)
+	if req.Workflow != "" {
+		if _, err := saveUserConfig(user, req.Workflow, []byte(config)); err != nil {
+			log.Printf("ERROR: unable to save config of workflow %s, error %v", req.Workflow, err)
+			writeJSON(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+	rec := make(map[string]any)
+	rec["workflow"] = req.Workflow
+	rec["items"] = pipeline.Items()
+	rec["config"] = config
+	writeJSON(w, rec, http.StatusOK)
+}
+
+// userNotebook defines name of user's notebook with CHAP user code
+const userNotebook = "userprocessor.ipynb"
+
+// helper function to capture user code from cells of user's notebook and
+// additional user notebooks which may define other user processors
+func captureUserCode(user string, notebooks ...string) ([]string, error) {
+	var lines []string
+	for _, fname := range append([]string{userNotebook}, notebooks...) {
+		notebook := Notebook{
+			Host:     Config.JupyterHost,
+			Token:    Config.JupyterToken,
+			Root:     Config.JupyterRoot,
+			User:     user,
+			FileName: fname}
+		rec, err := notebook.Capture()
+		if err != nil {
+			return nil, err
+		}
+		for _, cell := range rec.Content.Cells {
+			// metadata of the cell may define kind and class of user item
+			if marker := cellMarker(cell); marker != "" {
+				cell.Source = marker + "\n" + cell.Source
+			}
+			lines = append(lines, cell.Source)
+		}
+		if Config.Verbose > 0 {
+			log.Printf("### CHAP %+v, error %v", rec, err)
+		}
+	}
+	return lines, nil
+}
+
+// helper function to get additional user notebooks from notebooks query
+// parameter or HTTP header, the notebooks are separated by comma
+func userNotebooks(r *http.Request) ([]string, error) {
+	value := r.URL.Query().Get("notebooks")
+	if value == "" {
+		value = r.Header.Get("notebooks")
+	}
+	var notebooks []string
+	for _, fname := range strings.Split(value, ",") {
+		fname = strings.TrimSpace(fname)
+		if fname == "" || fname == userNotebook {
+			continue
+		}
+		if !strings.HasSuffix(fname, ".ipynb") || strings.Contains(fname, "..") || strings.HasPrefix(fname, "/") {
+			return nil, fmt.Errorf("invalid notebook name '%s'", fname)
+		}
+		notebooks = append(notebooks, fname)
+	}
+	return notebooks, nil
+}
+
+// helper function to capture user code of the request
+func requestUserCode(r *http.Request, user string) ([]string, error) {
+	notebooks, err := userNotebooks(r)
+	if err != nil {
+		return nil, err
+	}
+	return captureUserCode(user, notebooks...)
+}
+
+// helper function to get user module and processor from HTTP headers
+func userModule(r *http.Request) (string, string) {
+	module := r.Header.Get("module")
+	if module == "" {
+		module = "userprocessor"
+	}
+	processor := r.Header.Get("processor")
+	if processor == "" {
+		processor = "UserProcessor"
+	}
+	return module, processor
+}
+
 // ChapRunHandler handles CHAP run page
 func ChapRunHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := makeTmpl("CHAP pipeline")
@@ -447,29 +697,15 @@ func ChapRunHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl["Title"] = fmt.Sprintf("CHAP pipeline (%s)", user)
 	tmpl["Base"] = Config.Base
 
-	// prepare notebook
-	notebook := Notebook{
-		Host:     Config.JupyterHost,
-		Token:    Config.JupyterToken,
-		Root:     Config.JupyterRoot,
-		User:     user,
-		FileName: "userprocessor.ipynb"}
-	tmpl["Notebook"] = notebook.FileName
-	// capture notebook content
-	rec, err := notebook.Capture()
+	// capture user code from notebook
+	tmpl["Notebook"] = userNotebook
+	lines, err := requestUserCode(r, user)
 	if err != nil {
 		tmpl["Error"] = err
 		tmpl["HttpCode"] = http.StatusBadRequest
 		httpResponse(w, r, tmpl)
 		return
 	}
-	var lines []string
-	for _, cell := range rec.Content.Cells {
-		lines = append(lines, cell.Source)
-	}
-	if Config.Verbose > 0 {
-		log.Printf("### CHAP %+v, error %v", rec, err)
-	}
 
 	// get reader, writer parameters
 	params, err := url.ParseQuery(r.URL.RawQuery)
@@ -491,41 +727,77 @@ func ChapRunHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// add template entries about our workflow
-	udir := fmt.Sprintf("%s/users/%s/%s", Config.Base, user, workflow)
 	tmpl["User"] = user
-	tmpl["UserArea"] = udir
 	tmpl["Workflow"] = workflow
-	tmpl["UserConfig"] = fmt.Sprintf("%s/%s/%s/run-chap.yaml", Config.UserDir, user, workflow)
 
-	// generate user codebase
-	module := r.Header.Get("module")
-	if module == "" {
-		module = "userprocessor"
-	}
-	processor := r.Header.Get("processor")
-	if processor == "" {
-		processor = "UserProcessor"
-	}
-	genUserCode(user, workflow, module, processor, lines)
+	// user codebase parameters
+	module, processor := userModule(r)
 	tmpl["UserCode"] = fmt.Sprintf("%s.py", module)
 
-	// generate user config
-	if Config.Verbose > 0 {
-		log.Printf("### GENERATE NOTEBOOK workflow=%s", workflow)
+	// select executor of CHAP pipeline, the batch requests use dedicated
+	// executor while others may explicitly ask for specific one
+	var executorName string
+	if values, ok := params["executor"]; ok {
+		executorName = values[0]
 	}
-	config := genWorkflowConfig(user, module, workflow)
-
-	// run CHAP pipeline
-	batch := false
 	if r.Header.Get("batch") == "true" {
-		batch = true
+		executorName = Config.BatchExecutor
+	}
+	executorName = selectExecutor(executorName, workflow)
+	if _, err := getExecutor(executorName); err != nil {
+		tmpl["Error"] = err
+		tmpl["HttpCode"] = http.StatusBadRequest
+		httpResponse(w, r, tmpl)
+		return
+	}
+
+	// resolve email address to notify when the run is finished, users may
+	// opt in per run or for all batch runs in their settings
+	notify, err := notifyAddress(user, params.Get("notify"), r.Header.Get("batch") == "true")
+	if err != nil {
+		tmpl["Error"] = err
+		tmpl["HttpCode"] = http.StatusBadRequest
+		httpResponse(w, r, tmpl)
+		return
 	}
-	status := "success"
-	if _, err = runCHAP(user, config, workflow, batch); err != nil {
-		status = "error"
+
+	// user overrides of pipeline item arguments, e.g.
+	// overrides=[{"index": 0, "key": "filename", "value": "scan.yaml"}]
+	overrides, err := parseOverrides(params.Get("overrides"))
+	if err != nil {
 		tmpl["Error"] = err
+		tmpl["HttpCode"] = http.StatusBadRequest
+		httpResponse(w, r, tmpl)
+		return
+	}
+
+	// submit CHAP pipeline job
+	job, err := submitRun(RunRequest{
+		User:      user,
+		Workflow:  workflow,
+		Module:    module,
+		Processor: processor,
+		Executor:  executorName,
+		Lines:     lines,
+		Profile:   r.Header.Get("profile") == "true",
+		Notify:    notify,
+		Overrides: overrides,
+	})
+	if err != nil {
+		replyError(w, r, tmpl, submitErrorCode(err, http.StatusInternalServerError), err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, job, http.StatusAccepted)
+		return
 	}
-	tmpl["Status"] = status
+	tmpl["JobID"] = job.ID
+	tmpl["Status"] = job.Status
+	tmpl["UserArea"] = runURL(user, workflow, job.ID)
+	tmpl["LatestArea"] = fmt.Sprintf("%s/users/%s/%s/latest", Config.Base, user, workflow)
+	tmpl["Profile"] = job.Profile
+	tmpl["Notify"] = job.Notify
+	tmpl["Overrides"] = job.Overrides
 	content := tmplPage("output.tmpl", tmpl)
 
 	// prepare web response
@@ -537,6 +809,757 @@ func ChapRunHandler(w http.ResponseWriter, r *http.Request) {
 	httpResponse(w, r, tmpl)
 }
 
+// ChapRunsHandler provides history of user's CHAP runs
+func ChapRunsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP runs")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	runs, err := userRuns(user)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, runs, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Runs"] = runs
+	tmpl["Template"] = "runs.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapUsageHandler provides disk usage of user area
+func ChapUsageHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP disk usage")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	usage, err := userUsage(user)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, usage, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Usage"] = usage
+	tmpl["Retention"] = Config.Retention
+	tmpl["Template"] = "usage.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapSweepHandler submits parameter sweep of CHAP workflow, the sweep
+// request can be provided either as JSON body or as sweep form value
+func ChapSweepHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP sweep")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	var req SweepRequest
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&req)
+	} else {
+		err = json.Unmarshal([]byte(r.FormValue("sweep")), &req)
+	}
+	if err == nil {
+		req.Executor = selectExecutor(req.Executor, req.Workflow)
+		_, err = getExecutor(req.Executor)
+	}
+	var lines []string
+	if err == nil {
+		lines, err = requestUserCode(r, user)
+	}
+	var sweep Sweep
+	if err == nil {
+		module, processor := userModule(r)
+		sweep, err = submitSweep(user, module, processor, lines, req)
+	}
+	if err != nil {
+		replyError(w, r, tmpl, submitErrorCode(err, http.StatusBadRequest), err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, sweep, http.StatusAccepted)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/sweeps/%s", Config.Base, sweep.ID), http.StatusSeeOther)
+}
+
+// ChapSweepsHandler provides list of user's parameter sweeps
+func ChapSweepsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP sweeps")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	sweeps, err := userSweeps(user)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, sweeps, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Sweeps"] = sweeps
+	tmpl["Workflows"] = getChapWorkflows()
+	tmpl["Template"] = "sweeps.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapSweepStatusHandler provides summary of parameter sweep
+func ChapSweepStatusHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP sweep")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	sweep, err := getSweep(user, id)
+	if err != nil {
+		err = fmt.Errorf("unable to find sweep %s, error %v", id, err)
+		replyError(w, r, tmpl, http.StatusNotFound, err)
+		return
+	}
+	runs := sweepStatus(sweep)
+	if acceptJSON(r) {
+		writeJSON(w, map[string]any{"sweep": sweep, "runs": runs}, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Sweep"] = sweep
+	tmpl["Runs"] = runs
+	tmpl["Template"] = "sweep.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapChainHandler submits chain of CHAP workflows, the chain request can
+// be provided either as JSON body or as chain form value
+func ChapChainHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP chain")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	var req ChainRequest
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&req)
+	} else {
+		err = json.Unmarshal([]byte(r.FormValue("chain")), &req)
+	}
+	var lines []string
+	if err == nil {
+		lines, err = requestUserCode(r, user)
+	}
+	var chain Chain
+	if err == nil {
+		module, processor := userModule(r)
+		chain, err = submitChain(user, module, processor, lines, req)
+	}
+	if err != nil {
+		replyError(w, r, tmpl, submitErrorCode(err, http.StatusBadRequest), err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, chain, http.StatusAccepted)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/chains/%s", Config.Base, chain.ID), http.StatusSeeOther)
+}
+
+// ChapChainsHandler provides list of user's workflow chains
+func ChapChainsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP chains")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	chains, err := userChains(user)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, chains, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Chains"] = chains
+	tmpl["Workflows"] = getChapWorkflows()
+	tmpl["Template"] = "chains.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapChainStatusHandler provides status of workflow chain
+func ChapChainStatusHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP chain")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	chain, err := getChain(user, id)
+	if err != nil {
+		err = fmt.Errorf("unable to find chain %s, error %v", id, err)
+		replyError(w, r, tmpl, http.StatusNotFound, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, chain, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Chain"] = chain
+	tmpl["Template"] = "chain.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapRerunChainHandler reruns workflow chain from given stage
+func ChapRerunChainHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP chain")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	chain, err := rerunChain(user, id, r.FormValue("stage"))
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, chain, http.StatusAccepted)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/chains/%s", Config.Base, chain.ID), http.StatusSeeOther)
+}
+
+// ChapSettingsHandler provides and updates settings of the user
+func ChapSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP settings")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	settings := getSettings(user)
+	if r.Method == "POST" {
+		if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+			defer r.Body.Close()
+			err = json.NewDecoder(r.Body).Decode(&settings)
+		} else {
+			settings.Email = r.FormValue("email")
+			settings.NotifyBatch = r.FormValue("notify_batch") != ""
+		}
+		settings.User = user
+		if err == nil {
+			err = saveSettings(settings)
+		}
+		if err != nil {
+			replyError(w, r, tmpl, http.StatusBadRequest, err)
+			return
+		}
+		settings = getSettings(user)
+	}
+	if acceptJSON(r) {
+		writeJSON(w, settings, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Settings"] = settings
+	tmpl["EmailEnabled"] = Config.SMTP.Enabled()
+	tmpl["Template"] = "settings.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapWebhookHandler registers webhook of the user
+func ChapWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP webhook")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	var hook Webhook
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&hook)
+	} else {
+		r.ParseForm()
+		hook.URL = r.FormValue("url")
+		hook.Secret = r.FormValue("secret")
+		hook.Events = r.Form["events"]
+	}
+	if err == nil {
+		hook.User = user
+		hook, err = createWebhook(hook)
+	}
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, hook, http.StatusCreated)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/webhooks", Config.Base), http.StatusSeeOther)
+}
+
+// ChapWebhooksHandler provides list of user's webhooks and their deliveries
+func ChapWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP webhooks")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	hooks, err := userWebhooks(user)
+	var deliveries []Delivery
+	if err == nil {
+		deliveries, err = userDeliveries(user)
+	}
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, map[string]any{"webhooks": hooks, "deliveries": deliveries}, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Webhooks"] = hooks
+	tmpl["Deliveries"] = deliveries
+	tmpl["Events"] = webhookEvents
+	tmpl["Template"] = "webhooks.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapDeleteWebhookHandler deletes user's webhook
+func ChapDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP webhook")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	if err := deleteWebhook(user, id); err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, map[string]string{"id": id, "status": "deleted"}, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/webhooks", Config.Base), http.StatusSeeOther)
+}
+
+// ChapTestWebhookHandler sends test notification to user's webhook
+func ChapTestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP webhook")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	delivery, err := testWebhook(user, params.ByName("id"))
+	if err != nil && delivery.ID == "" {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		rec := map[string]any{"id": delivery.ID, "webhook": delivery.Webhook, "success": delivery.Success}
+		code := http.StatusOK
+		if err != nil {
+			rec["error"] = err.Error()
+			code = http.StatusBadGateway
+		}
+		writeJSON(w, rec, code)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/webhooks", Config.Base), http.StatusSeeOther)
+}
+
+// ChapScheduleHandler creates new schedule of CHAP workflow, the user code
+// is captured from user's notebook at schedule creation time
+func ChapScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP schedule")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	var rec Schedule
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&rec)
+	} else {
+		rec.Workflow = r.FormValue("workflow")
+		rec.Cron = r.FormValue("cron")
+		rec.Executor = r.FormValue("executor")
+	}
+	if err == nil {
+		rec.User = user
+		rec.Module, rec.Processor = userModule(r)
+		rec.Lines, err = requestUserCode(r, user)
+	}
+	if err == nil {
+		rec, err = createSchedule(rec)
+	}
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, rec, http.StatusCreated)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/schedules", Config.Base), http.StatusSeeOther)
+}
+
+// ChapSchedulesHandler provides list of user's schedules
+func ChapSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP schedules")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	schedules, err := userSchedules(user)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusInternalServerError, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, schedules, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Schedules"] = schedules
+	tmpl["Workflows"] = getChapWorkflows()
+	tmpl["Template"] = "schedules.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapPauseScheduleHandler pauses user's schedule
+func ChapPauseScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	pauseScheduleHandler(w, r, true)
+}
+
+// ChapResumeScheduleHandler resumes user's schedule
+func ChapResumeScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	pauseScheduleHandler(w, r, false)
+}
+
+// helper function to pause or resume user's schedule
+func pauseScheduleHandler(w http.ResponseWriter, r *http.Request, pause bool) {
+	tmpl := makeTmpl("CHAP schedule")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	rec, err := pauseSchedule(user, params.ByName("id"), pause)
+	if err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, rec, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/schedules", Config.Base), http.StatusSeeOther)
+}
+
+// ChapDeleteScheduleHandler deletes user's schedule
+func ChapDeleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP schedule")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	if err := deleteSchedule(user, id); err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, map[string]string{"id": id, "status": "deleted"}, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/schedules", Config.Base), http.StatusSeeOther)
+}
+
+// ChapRunProfileHandler provides profile report of CHAP run
+func ChapRunProfileHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP profile")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	rec, err := getRun(user, id)
+	if err == nil && !rec.Profile {
+		err = errors.New("run was not executed in profile mode")
+	}
+	var report ProfileReport
+	if err == nil {
+		report, err = readProfile(runDir(rec.User, rec.Workflow, rec.ID))
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to get profile of run %s, error %v", id, err)
+		replyError(w, r, tmpl, http.StatusNotFound, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, report, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Run"] = rec
+	tmpl["Report"] = report
+	tmpl["ProfileData"] = fmt.Sprintf("%s/%s", rec.Output, profileData)
+	tmpl["ProfileStacks"] = fmt.Sprintf("%s/%s", rec.Output, profileStacks)
+	tmpl["Template"] = "profile.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapRunManifestHandler provides manifest of files produced by CHAP run
+func ChapRunManifestHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getUser(r)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	rec, err := getRun(user, id)
+	if err != nil {
+		err = fmt.Errorf("unable to find run %s, error %v", id, err)
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+		return
+	}
+	manifest, err := readManifest(runDir(rec.User, rec.Workflow, rec.ID))
+	if err != nil {
+		err = fmt.Errorf("manifest of run %s is not available, error %v", id, err)
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, manifest, http.StatusOK)
+}
+
+// ChapRunConfigHandler provides CHAP configuration used by the run, use
+// Accept: application/json HTTP header to get it together with user overrides
+func ChapRunConfigHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getUser(r)
+	if err != nil {
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	cfg, err := getRunConfig(user, id)
+	if err != nil {
+		err = fmt.Errorf("configuration of run %s is not available, error %v", id, err)
+		writeJSON(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, cfg, http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write([]byte(cfg.Config))
+}
+
+// ChapPinHandler pins CHAP run to protect it from removal
+func ChapPinHandler(w http.ResponseWriter, r *http.Request) {
+	pinRunHandler(w, r, true)
+}
+
+// ChapUnpinHandler unpins CHAP run
+func ChapUnpinHandler(w http.ResponseWriter, r *http.Request) {
+	pinRunHandler(w, r, false)
+}
+
+// helper function to pin or unpin CHAP run
+func pinRunHandler(w http.ResponseWriter, r *http.Request, pin bool) {
+	tmpl := makeTmpl("CHAP run")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	if err := pinRun(user, id, pin); err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		rec, _ := getRun(user, id)
+		writeJSON(w, rec, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/runs", Config.Base), http.StatusSeeOther)
+}
+
+// ChapDeleteRunHandler deletes CHAP run along with its outputs
+func ChapDeleteRunHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP run")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	if err := deleteRun(user, id); err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, map[string]string{"id": id, "status": "deleted"}, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/runs", Config.Base), http.StatusSeeOther)
+}
+
+// ChapJobsHandler provides list of user's CHAP jobs
+func ChapJobsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP jobs")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	jobs := jobManager.UserJobs(user)
+	if acceptJSON(r) {
+		writeJSON(w, jobs, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Jobs"] = jobs
+	tmpl["Template"] = "jobs.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapJobHandler provides status of CHAP job
+func ChapJobHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP job")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	job, ok := jobManager.Get(id)
+	if !ok || job.User != user {
+		err := fmt.Errorf("unable to find job %s", id)
+		replyError(w, r, tmpl, http.StatusNotFound, err)
+		return
+	}
+	if acceptJSON(r) {
+		writeJSON(w, job, http.StatusOK)
+		return
+	}
+	tmpl["User"] = user
+	tmpl["Job"] = job
+	tmpl["Duration"] = job.Duration().Round(time.Second).String()
+	tmpl["RunArea"] = runURL(job.User, job.Workflow, job.ID)
+	if job.Done() {
+		if manifest, err := readManifest(job.Dir); err == nil {
+			tmpl["Manifest"] = manifest.Files
+		}
+	}
+	tmpl["Template"] = "job.tmpl"
+	httpResponse(w, r, tmpl)
+}
+
+// ChapJobLogHandler streams CHAP log of the job as server-sent events
+func ChapJobLogHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	if job, ok := jobManager.Get(id); !ok || job.User != user {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unable to find job %s", id)))
+		return
+	}
+	if err := streamJobLog(w, r, id); err != nil {
+		log.Printf("ERROR: unable to stream log of job %s, error %v", id, err)
+	}
+}
+
+// ChapCancelHandler cancels CHAP job, the job can be specified either by
+// its id or by workflow name of user's active job
+func ChapCancelHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := makeTmpl("CHAP cancel")
+	user, err := getUser(r)
+	if err != nil {
+		httpError(w, r, tmpl, SessionError, err, http.StatusBadRequest)
+		return
+	}
+	params := bunrouter.ParamsFromContext(r.Context())
+	var job Job
+	var ok bool
+	if workflow := params.ByName("workflow"); workflow != "" {
+		job, ok = jobManager.ActiveJob(user, workflow)
+	} else {
+		job, ok = jobManager.Get(params.ByName("id"))
+	}
+	if !ok || job.User != user {
+		err := errors.New("unable to find CHAP job to cancel")
+		replyError(w, r, tmpl, http.StatusNotFound, err)
+		return
+	}
+	if err := jobManager.Cancel(job.ID); err != nil {
+		replyError(w, r, tmpl, http.StatusBadRequest, err)
+		return
+	}
+	if acceptJSON(r) {
+		job, _ = jobManager.Get(job.ID)
+		writeJSON(w, job, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/chap/jobs/%s", Config.Base, job.ID), http.StatusSeeOther)
+}
+
 // ChapBatchHandler handles CHAP batch page
 func ChapBatchHandler(w http.ResponseWriter, r *http.Request) {
 	// set batch HTTP header
@@ -0,0 +1,691 @@
+package main
+
+// jobs module provides asynchronous execution of CHAP pipelines
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// list of CHAP job states
+const (
+	JobQueued    = "queued"    // job is waiting for a free worker
+	JobRunning   = "running"   // job is executed by a worker
+	JobFinished  = "finished"  // job successfully finished
+	JobFailed    = "failed"    // job finished with an error
+	JobCancelled = "cancelled" // job was cancelled by the user
+)
+
+// cancelGracePeriod defines how long we wait for CHAP processes to
+// terminate before killing them
+var cancelGracePeriod = 10 * time.Second
+
+// errors of job submission, they allow clients to distinguish invalid
+// requests from temporary conditions of the server
+var (
+	// ErrInvalidRun is returned when run request or its configuration is invalid
+	ErrInvalidRun = errors.New("invalid run request")
+	// ErrActiveJob is returned when user's workflow already has active job
+	ErrActiveJob = errors.New("workflow already has active job")
+	// ErrQueueFull is returned when job queue has no free slots
+	ErrQueueFull = errors.New("job queue is full, please try later")
+)
+
+// Job represents single CHAP pipeline run
+type Job struct {
+	ID         string      `json:"id"`          // job identifier
+	User       string      `json:"user"`        // user name
+	Workflow   string      `json:"workflow"`    // workflow name
+	Module     string      `json:"module"`      // user python module
+	Processor  string      `json:"processor"`   // user processor class
+	Executor   string      `json:"executor"`    // executor name
+	ExecID     string      `json:"exec_id"`     // job id within executor
+	ExecInfo   string      `json:"exec_info"`   // executor status information
+	Status     string      `json:"status"`      // job status
+	SubmitTime time.Time   `json:"submit_time"` // job submission time
+	StartTime  time.Time   `json:"start_time"`  // job start time
+	EndTime    time.Time   `json:"end_time"`    // job end time
+	ExitCode   int         `json:"exit_code"`   // exit code of CHAP pipeline
+	Error      string      `json:"error"`       // error message
+	Reason     string      `json:"reason"`      // reason of job termination
+	Limits     Limits      `json:"limits"`      // resource limits of the job
+	Profile    bool        `json:"profile"`     // run CHAP pipeline in profile mode
+	Sweep      string      `json:"sweep"`       // parameter sweep of the job
+	Schedule   string      `json:"schedule"`    // schedule which fired the job
+	Chain      string      `json:"chain"`       // workflow chain of the job
+	Inputs     []string    `json:"inputs"`      // run directories of upstream chain stages
+	Notify     string      `json:"notify"`      // email address to notify when job is finished
+	Overrides  []Override  `json:"overrides"`   // user overrides of workflow configuration
+	Retry      RetryPolicy `json:"retry"`       // retry policy of the job
+	Attempts   []Attempt   `json:"attempts"`    // attempts of the job
+	Dir        string      `json:"-"`           // job directory
+	Outputs    []string    `json:"outputs"`     // output paths of the job
+	Lines      []string    `json:"-"`           // captured user code
+	Config     string      `json:"-"`           // CHAP pipeline configuration
+}
+
+// ToJSON provides string representation of Job
+func (j Job) ToJSON() string {
+	data, _ := json.MarshalIndent(j, "", "    ")
+	return string(data)
+}
+
+// Duration returns job execution time
+func (j Job) Duration() time.Duration {
+	if j.StartTime.IsZero() {
+		return 0
+	}
+	if j.EndTime.IsZero() {
+		return time.Since(j.StartTime)
+	}
+	return j.EndTime.Sub(j.StartTime)
+}
+
+// Done returns true if job reached its final state
+func (j Job) Done() bool {
+	return j.Status == JobFinished || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// AttemptStart returns start time of current attempt of the job
+func (j Job) AttemptStart() time.Time {
+	if len(j.Attempts) > 0 {
+		return j.Attempts[len(j.Attempts)-1].StartTime
+	}
+	return j.StartTime
+}
+
+// Key returns user and workflow key of the job
+func (j Job) Key() string {
+	return runKey(j.User, j.Workflow)
+}
+
+// helper function to construct key of user's workflow
+func runKey(user, workflow string) string {
+	return fmt.Sprintf("%s/%s", user, workflow)
+}
+
+// JobManager keeps track of CHAP jobs and executes them by pool of workers
+type JobManager struct {
+	sync.RWMutex
+	Jobs    map[string]*Job        // jobs known to the server
+	Active  map[string]string      // active job ids keyed by user and workflow
+	Queue   chan *Job              // queue of jobs to execute
+	retries map[string]*time.Timer // timers of jobs waiting for their next attempt
+}
+
+// jobManager holds our job manager
+var jobManager *JobManager
+
+// helper function to initialize job manager with given number of workers,
+// the finished jobs are kept in memory for given ttl while their records
+// remain in run history
+func initJobManager(workers, size int, ttl time.Duration) {
+	jobManager = newJobManager(size)
+	for i := 0; i < workers; i++ {
+		go jobManager.worker()
+	}
+	go jobManager.expire(ttl)
+	log.Printf("job manager started with %d workers, queue size %d and job ttl %v", workers, size, ttl)
+}
+
+// helper function to create job manager with given queue size
+func newJobManager(size int) *JobManager {
+	return &JobManager{
+		Jobs:    make(map[string]*Job),
+		Active:  make(map[string]string),
+		Queue:   make(chan *Job, size),
+		retries: make(map[string]*time.Timer),
+	}
+}
+
+// Submit adds new job to the queue of job manager, only one active job
+// is allowed for user's workflow while jobs of parameter sweeps and
+// workflow chains are not restricted
+func (m *JobManager) Submit(job *Job) error {
+	job.Status = JobQueued
+	job.SubmitTime = time.Now()
+	exclusive := job.Sweep == "" && job.Chain == ""
+	m.Lock()
+	if id, ok := m.Active[job.Key()]; ok && exclusive {
+		m.Unlock()
+		return fmt.Errorf("%w, workflow %s job %s", ErrActiveJob, job.Workflow, id)
+	}
+	m.Jobs[job.ID] = job
+	if exclusive {
+		m.Active[job.Key()] = job.ID
+	}
+	m.Unlock()
+	select {
+	case m.Queue <- job:
+		if Config.Verbose > 0 {
+			log.Printf("job %s of user %s is queued", job.ID, job.User)
+		}
+		m.save(job)
+		return nil
+	default:
+		m.Lock()
+		delete(m.Jobs, job.ID)
+		m.Unlock()
+		m.release(job)
+		return ErrQueueFull
+	}
+}
+
+// Free returns number of free slots in job queue
+func (m *JobManager) Free() int {
+	return cap(m.Queue) - len(m.Queue)
+}
+
+// Get returns copy of a job for given job id
+func (m *JobManager) Get(id string) (Job, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if job, ok := m.Jobs[id]; ok {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// ActiveJob returns active job of user's workflow
+func (m *JobManager) ActiveJob(user, workflow string) (Job, bool) {
+	m.RLock()
+	id, ok := m.Active[runKey(user, workflow)]
+	m.RUnlock()
+	if !ok {
+		return Job{}, false
+	}
+	return m.Get(id)
+}
+
+// Cancel cancels given job, the queued job will be removed from execution
+// while running job will be terminated along with all its child processes
+func (m *JobManager) Cancel(id string) error {
+	m.Lock()
+	job, ok := m.Jobs[id]
+	if !ok {
+		m.Unlock()
+		return fmt.Errorf("unable to find job %s", id)
+	}
+	if job.Done() {
+		m.Unlock()
+		return fmt.Errorf("job %s is already %s", id, job.Status)
+	}
+	status := job.Status
+	job.Status = JobCancelled
+	job.EndTime = time.Now()
+	rec := *job
+	// the job waiting for its next attempt is returned to the queue right
+	// away and its worker will finalize it
+	if timer, ok := m.retries[id]; ok && timer.Stop() {
+		delete(m.retries, id)
+		go m.requeue(job)
+	}
+	m.Unlock()
+	log.Printf("cancel job %s user=%s workflow=%s", job.ID, job.User, job.Workflow)
+	saveRun(rec)
+	if status == JobQueued {
+		// the job will never start, the running job notifies webhooks
+		// when its worker finishes it
+		notifyRun(rec)
+	}
+	if status == JobQueued || rec.ExecID == "" {
+		// job is not yet submitted to executor, the worker will
+		// take care of it
+		return nil
+	}
+	executor, err := getExecutor(rec.Executor)
+	if err != nil {
+		return err
+	}
+	return executor.Cancel(rec)
+}
+
+// helper function to release user's workflow from active job
+func (m *JobManager) release(job *Job) {
+	m.Lock()
+	defer m.Unlock()
+	if id, ok := m.Active[job.Key()]; ok && id == job.ID {
+		delete(m.Active, job.Key())
+	}
+}
+
+// UserJobs returns list of jobs for given user sorted by submission time
+func (m *JobManager) UserJobs(user string) []Job {
+	var jobs []Job
+	m.RLock()
+	for _, job := range m.Jobs {
+		if job.User == user {
+			jobs = append(jobs, *job)
+		}
+	}
+	m.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].SubmitTime.After(jobs[j].SubmitTime)
+	})
+	return jobs
+}
+
+// Expire removes jobs which are finished longer than given ttl ago, it
+// returns number of removed jobs
+func (m *JobManager) Expire(ttl time.Duration) int {
+	m.Lock()
+	defer m.Unlock()
+	var count int
+	for id, job := range m.Jobs {
+		if job.Done() && !job.EndTime.IsZero() && time.Since(job.EndTime) > ttl {
+			delete(m.Jobs, id)
+			count++
+		}
+	}
+	return count
+}
+
+// helper function to periodically remove expired jobs
+func (m *JobManager) expire(ttl time.Duration) {
+	interval := ttl
+	if interval > 10*time.Minute {
+		interval = 10 * time.Minute
+	}
+	for {
+		time.Sleep(interval)
+		if count := m.Expire(ttl); count > 0 && Config.Verbose > 0 {
+			log.Printf("job manager removed %d expired jobs", count)
+		}
+	}
+}
+
+// helper function to save current state of the job in run history
+func (m *JobManager) save(job *Job) {
+	if j, ok := m.Get(job.ID); ok {
+		saveRun(j)
+	}
+}
+
+// helper function to update job attributes under the lock
+func (m *JobManager) update(job *Job, f func(j *Job)) {
+	m.Lock()
+	defer m.Unlock()
+	f(job)
+}
+
+// worker executes jobs from the queue
+func (m *JobManager) worker() {
+	for job := range m.Queue {
+		m.run(job)
+	}
+}
+
+// helper function to execute given job, the job which waits for its next
+// attempt is returned to the queue when its delay is passed
+func (m *JobManager) run(job *Job) {
+	var retry, cancelled bool
+	m.update(job, func(j *Job) {
+		retry = len(j.Attempts) > 0
+		if j.Status == JobCancelled {
+			cancelled = true
+			return
+		}
+		if !retry {
+			j.Status = JobRunning
+			j.StartTime = time.Now()
+		}
+	})
+	if cancelled && !retry {
+		m.release(job)
+		return
+	}
+	var status ExecStatus
+	var err error
+	if !retry {
+		log.Printf("start job %s user=%s workflow=%s", job.ID, job.User, job.Workflow)
+		m.save(job)
+		if j, ok := m.Get(job.ID); ok {
+			notifyRun(j)
+			go notifyEmail(j)
+		}
+
+		// create run directory, generate user code and stage inputs of
+		// chain stage, the job fails without its run directory
+		err = initRunDir(job.User, job.Workflow, job.ID)
+		if err != nil {
+			log.Printf("ERROR: unable to create run directory %s, error %v", job.Dir, err)
+			err = fmt.Errorf("unable to create run directory, error %v", err)
+		} else {
+			genUserCode(job.User, job.Dir, job.Module, job.Processor, job.Lines)
+			err = stageInputs(*job)
+		}
+	}
+	if err == nil && !cancelled {
+		// run CHAP pipeline
+		var retried bool
+		if status, retried, err = m.attempt(job); retried {
+			return
+		}
+	}
+	m.finish(job, status, err)
+}
+
+// helper function to finalize the job and write manifest of its run
+// directory before we expose final status of the job
+func (m *JobManager) finish(job *Job, status ExecStatus, err error) {
+	defer m.release(job)
+	if j, ok := m.Get(job.ID); ok && j.Status == JobCancelled {
+		cleanupJob(j)
+	}
+	var rec Job
+	m.update(job, func(j *Job) {
+		rec = *j
+	})
+	finishJob(&rec, status, err)
+	manifest, merr := writeManifest(rec)
+	if merr != nil {
+		log.Printf("ERROR: unable to write manifest of job %s, error %v", rec.ID, merr)
+	}
+	var outputs []string
+	for _, file := range manifest.Files {
+		outputs = append(outputs, file.URL)
+	}
+	m.update(job, func(j *Job) {
+		j.Outputs = outputs
+		if j.Status == JobCancelled {
+			return
+		}
+		j.EndTime = rec.EndTime
+		j.Status = rec.Status
+		j.ExitCode = rec.ExitCode
+		j.Error = rec.Error
+		j.Reason = rec.Reason
+	})
+	m.save(job)
+	pruneRuns(job.User, job.Workflow)
+	if j, ok := m.Get(job.ID); ok {
+		log.Printf("job %s finished with status=%s exit code=%d elapsed time %v", j.ID, j.Status, j.ExitCode, j.Duration())
+		notifyRun(j)
+		go notifyEmail(j)
+	}
+}
+
+// helper function to set final status of the job from its execution status
+func finishJob(j *Job, status ExecStatus, err error) {
+	if j.Status == JobCancelled {
+		return
+	}
+	j.EndTime = time.Now()
+	if err != nil {
+		j.Status = JobFailed
+		j.Error = err.Error()
+		j.ExitCode = exitCode(err)
+		return
+	}
+	j.Status = status.Status
+	j.ExitCode = status.ExitCode
+	j.Error = status.Error
+	if j.Reason == "" && j.Status == JobFailed {
+		j.Reason = limitReason(*j, status)
+	}
+}
+
+// helper function to execute next attempt of the job according to its
+// retry policy, the job with retryable failure is scheduled for the next
+// attempt after backoff delay and true is returned
+func (m *JobManager) attempt(job *Job) (ExecStatus, bool, error) {
+	var number int
+	m.update(job, func(j *Job) {
+		number = len(j.Attempts) + 1
+		attempt := Attempt{Number: number, StartTime: time.Now(), Status: JobRunning, Log: "chap.log"}
+		j.Attempts = append(j.Attempts, attempt)
+	})
+	status, err := m.execute(job)
+	result, code, msg := status.Status, status.ExitCode, status.Error
+	if err != nil {
+		result, code, msg = JobFailed, exitCode(err), err.Error()
+	}
+	var rec Job
+	m.update(job, func(j *Job) {
+		attempt := &j.Attempts[len(j.Attempts)-1]
+		attempt.ExecID = j.ExecID
+		attempt.EndTime = time.Now()
+		attempt.Status = result
+		attempt.ExitCode = code
+		attempt.Error = msg
+		if j.Status == JobCancelled {
+			attempt.Status = JobCancelled
+		}
+		rec = *j
+	})
+	// the limit violations and cancelled jobs are never retried
+	if result != JobFailed || rec.Status == JobCancelled || rec.Reason != "" || limitReason(rec, status) != "" {
+		return status, false, err
+	}
+	if number >= rec.Retry.MaxAttempts || !rec.Retry.Retryable(code, msg, readJobLog(rec)) {
+		return status, false, err
+	}
+	delay := rec.Retry.Delay(number)
+	log.Printf("job %s attempt %d failed with exit code %d, retry in %v", rec.ID, number, code, delay)
+	name := archiveAttemptLog(rec, number)
+	m.update(job, func(j *Job) {
+		j.Attempts[len(j.Attempts)-1].Log = name
+		j.ExecID = ""
+		j.ExecInfo = fmt.Sprintf("attempt %d will start in %v", number+1, delay)
+	})
+	m.save(job)
+	m.Lock()
+	defer m.Unlock()
+	if job.Status == JobCancelled {
+		// job was cancelled while we prepared its next attempt
+		return status, false, err
+	}
+	m.retries[job.ID] = time.AfterFunc(delay, func() {
+		m.Lock()
+		delete(m.retries, job.ID)
+		m.Unlock()
+		m.requeue(job)
+	})
+	return status, true, err
+}
+
+// helper function to return the job to the queue of job manager
+func (m *JobManager) requeue(job *Job) {
+	if Config.Verbose > 0 {
+		log.Printf("job %s of user %s is queued for next attempt", job.ID, job.User)
+	}
+	m.Queue <- job
+}
+
+// helper function to execute the job by its executor, it submits the job
+// and polls its status until job is finished
+func (m *JobManager) execute(job *Job) (ExecStatus, error) {
+	var status ExecStatus
+	executor, err := getExecutor(job.Executor)
+	if err != nil {
+		return status, err
+	}
+	id, err := executor.Submit(*job)
+	if err != nil {
+		return status, err
+	}
+	var rec Job
+	m.update(job, func(j *Job) {
+		j.ExecID = id
+		rec = *j
+	})
+	if rec.Status == JobCancelled {
+		// job was cancelled while we submitted it
+		if err := executor.Cancel(rec); err != nil {
+			log.Printf("ERROR: unable to cancel job %s, error %v", rec.ID, err)
+		}
+	}
+	interval := time.Duration(Config.PollInterval) * time.Second
+	var reason string
+	for {
+		status, err = executor.Status(rec)
+		if err != nil {
+			return status, err
+		}
+		m.update(job, func(j *Job) {
+			j.ExecInfo = status.Info
+		})
+		if status.Status != JobQueued && status.Status != JobRunning {
+			break
+		}
+		// terminate the job which exceeds its limits and wait for
+		// its completion
+		if reason == "" {
+			if reason = checkLimits(rec); reason != "" {
+				log.Printf("job %s exceeds its limits: %s", rec.ID, reason)
+				m.update(job, func(j *Job) {
+					j.Reason = reason
+				})
+				if err := executor.Cancel(rec); err != nil {
+					log.Printf("ERROR: unable to terminate job %s, error %v", rec.ID, err)
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+	if reason != "" {
+		status.Status = JobFailed
+		status.Error = reason
+	}
+	return status, nil
+}
+
+// helper function to clean up partial state of cancelled job, we remove
+// all files produced by the job except its log and configuration
+func cleanupJob(job Job) {
+	keep := map[string]bool{
+		"chap.log":                       true,
+		"run-chap.yaml":                  true,
+		fmt.Sprintf("%s.py", job.Module): true,
+	}
+	entries, err := os.ReadDir(job.Dir)
+	if err != nil {
+		log.Printf("ERROR: unable to read job directory %s, error %v", job.Dir, err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || keep[entry.Name()] || info.ModTime().Before(job.StartTime) {
+			continue
+		}
+		fname := filepath.Join(job.Dir, entry.Name())
+		if err := os.RemoveAll(fname); err != nil {
+			log.Printf("ERROR: unable to remove %s, error %v", fname, err)
+		}
+	}
+	fname := filepath.Join(job.Dir, "chap.log")
+	if file, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644); err == nil {
+		fmt.Fprintf(file, "\nCHAP job %s was cancelled at %s\n", job.ID, job.EndTime.Format(time.RFC3339))
+		file.Close()
+	}
+}
+
+// helper function to generate new job id, we use timestamp and random
+// suffix to provide human readable and unique identifier
+func newJobID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		log.Println("ERROR: unable to generate random job id", err)
+	}
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(buf))
+}
+
+// helper function to create new job for given user and workflow
+func newJob(user, workflow, module, processor string, lines []string, executor string) (*Job, error) {
+	config, err := genUserConfig(user, module, workflow, parseUserCode(lines, processor))
+	if err != nil {
+		return nil, err
+	}
+	id := newJobID()
+	return &Job{
+		ID:        id,
+		User:      user,
+		Workflow:  workflow,
+		Module:    module,
+		Processor: processor,
+		Executor:  executor,
+		Limits:    workflowLimits(workflow),
+		Retry:     workflowRetry(workflow),
+		Lines:     lines,
+		Dir:       runDir(user, workflow, id),
+		Config:    config,
+	}, nil
+}
+
+// RunRequest represents request to run CHAP pipeline of user's workflow
+type RunRequest struct {
+	User      string     // user name
+	Workflow  string     // workflow name
+	Module    string     // user python module
+	Processor string     // user processor class
+	Executor  string     // requested executor
+	Lines     []string   // captured user code
+	Profile   bool       // run CHAP pipeline in profile mode
+	Schedule  string     // schedule which fires the run
+	Notify    string     // email address to notify when run is finished
+	Overrides []Override // user overrides of workflow configuration
+}
+
+// helper function to submit CHAP pipeline job for given run request, the
+// user code and its configuration will be generated when job manager will
+// start the job, the returned errors wrap ErrInvalidRun, ErrQuotaExceeded,
+// ErrActiveJob or ErrQueueFull
+func submitRun(req RunRequest) (*Job, error) {
+	executor := selectExecutor(req.Executor, req.Workflow)
+	if _, err := getExecutor(executor); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidRun, err)
+	}
+	if err := checkQuota(req.User); err != nil {
+		return nil, err
+	}
+	job, err := newJob(req.User, req.Workflow, req.Module, req.Processor, req.Lines, executor)
+	if err != nil {
+		return nil, err
+	}
+	config, err := mergeOverrides(job.Config, req.Overrides)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidRun, err)
+	}
+	job.Config = config
+	job.Overrides = req.Overrides
+	if err := validateJob(job); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidRun, err)
+	}
+	job.Profile = req.Profile
+	job.Schedule = req.Schedule
+	job.Notify = req.Notify
+	if err := jobManager.Submit(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// helper function to extract exit code from execution error
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// follow shell convention for processes terminated by signal
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return -1
+}
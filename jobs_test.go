@@ -0,0 +1,230 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper function to write fake chap.sh script which runs given shell code
+// within run directory of the job, the script gets the same arguments as
+// scripts/chap.sh, i.e. workflow, config, workflow directory and run directory
+func fakeChap(t *testing.T, code string) string {
+	script := filepath.Join(t.TempDir(), "chap.sh")
+	content := "#!/bin/bash\ncd $4\n" + code + "\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+// helper function to setup job manager with local executor of given script
+func setupJobManager(t *testing.T, script string) {
+	Config.UserDir = t.TempDir()
+	Config.WorkflowsRoot = t.TempDir()
+	Config.PollInterval = 1
+	executors["local"] = NewLocalExecutor(script)
+	jobManager = newJobManager(10)
+	go jobManager.worker()
+	t.Cleanup(func() {
+		close(jobManager.Queue)
+		delete(executors, "local")
+		Config.UserDir = ""
+		Config.WorkflowsRoot = ""
+		Config.PollInterval = 0
+	})
+}
+
+// helper function to create test job of given workflow
+func testJob(workflow string) *Job {
+	id := newJobID()
+	return &Job{
+		ID:        id,
+		User:      "test",
+		Workflow:  workflow,
+		Module:    "mod",
+		Processor: "UserProcessor",
+		Executor:  "local",
+		Dir:       runDir("test", workflow, id),
+		Config:    "pipeline:\n  - common.PrintProcessor\n",
+	}
+}
+
+// helper function to wait for job to reach its final state
+func waitJob(t *testing.T, id string, timeout time.Duration) Job {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if job, ok := jobManager.Get(id); ok && job.Done() {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	job, _ := jobManager.Get(id)
+	t.Fatalf("job %s is not finished within %v, status %s", id, timeout, job.Status)
+	return job
+}
+
+// TestJobManager tests execution of CHAP jobs by job manager
+func TestJobManager(t *testing.T) {
+	script := fakeChap(t, `echo "workflow $1" > chap.log
+echo "config $(basename $2)" >> chap.log
+sleep 0.5
+if [ "$1" == "broken" ]; then
+    echo "CHAP failed" >> chap.log
+    exit 3
+fi
+echo "CHAP done" >> chap.log`)
+	setupJobManager(t, script)
+
+	job := testJob("wflow")
+	if err := jobManager.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	// only one active job is allowed for user's workflow
+	if err := jobManager.Submit(testJob("wflow")); err == nil {
+		t.Error("second active job of the workflow is accepted")
+	}
+	if active, ok := jobManager.ActiveJob("test", "wflow"); !ok || active.ID != job.ID {
+		t.Errorf("wrong active job %+v", active)
+	}
+	rec := waitJob(t, job.ID, 10*time.Second)
+	if rec.Status != JobFinished || rec.ExitCode != 0 || rec.Error != "" {
+		t.Errorf("wrong job status=%s exit code=%d error=%s", rec.Status, rec.ExitCode, rec.Error)
+	}
+	if rec.StartTime.IsZero() || rec.EndTime.Before(rec.StartTime) {
+		t.Errorf("wrong job times start=%v end=%v", rec.StartTime, rec.EndTime)
+	}
+	data, err := os.ReadFile(filepath.Join(rec.Dir, "chap.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "workflow wflow\nconfig run-chap.yaml\nCHAP done\n" {
+		t.Errorf("wrong CHAP log:\n%s", data)
+	}
+	for _, fname := range []string{"mod.py", "run-chap.yaml", "manifest.json"} {
+		if _, err := os.Stat(filepath.Join(rec.Dir, fname)); err != nil {
+			t.Errorf("job does not produce %s, error %v", fname, err)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(Config.UserDir, "test", "wflow", "latest")); err != nil || link != filepath.Join("runs", job.ID) {
+		t.Errorf("wrong latest link %s, error %v", link, err)
+	}
+	if _, ok := jobManager.ActiveJob("test", "wflow"); ok {
+		t.Error("finished job is still active")
+	}
+
+	// failed job reports exit code of CHAP pipeline
+	job = testJob("broken")
+	if err := jobManager.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	rec = waitJob(t, job.ID, 10*time.Second)
+	if rec.Status != JobFailed || rec.ExitCode != 3 {
+		t.Errorf("wrong job status=%s exit code=%d", rec.Status, rec.ExitCode)
+	}
+	if jobs := jobManager.UserJobs("test"); len(jobs) != 2 || jobs[0].ID != job.ID {
+		t.Errorf("wrong user jobs %d", len(jobs))
+	}
+
+	// finished jobs are removed from memory after their ttl
+	if count := jobManager.Expire(time.Hour); count != 0 {
+		t.Errorf("recent jobs are expired %d", count)
+	}
+	jobManager.update(jobManager.Jobs[job.ID], func(j *Job) {
+		j.EndTime = time.Now().Add(-2 * time.Hour)
+	})
+	if count := jobManager.Expire(time.Hour); count != 1 {
+		t.Errorf("wrong number of expired jobs %d", count)
+	}
+	if _, ok := jobManager.Get(job.ID); ok {
+		t.Error("expired job is still known to job manager")
+	}
+}
+
+// TestJobManagerRunDir tests that job fails when its run directory can not
+// be created
+func TestJobManagerRunDir(t *testing.T) {
+	setupJobManager(t, fakeChap(t, "echo CHAP done > chap.log"))
+	// user area is a file and run directory can not be created there
+	fname := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(fname, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	Config.UserDir = fname
+	job := testJob("wflow")
+	if err := jobManager.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	rec := waitJob(t, job.ID, 10*time.Second)
+	if rec.Status != JobFailed || !strings.Contains(rec.Error, "unable to create run directory") {
+		t.Errorf("wrong job status=%s error=%s", rec.Status, rec.Error)
+	}
+	if len(rec.Attempts) != 0 {
+		t.Errorf("job without run directory is executed %d times", len(rec.Attempts))
+	}
+}
+
+// TestSubmitRunErrors tests that errors of run submission can be
+// distinguished by clients
+func TestSubmitRunErrors(t *testing.T) {
+	setupStorage(t)
+	Config.UserDir = t.TempDir()
+	Config.WorkflowsRoot = t.TempDir()
+	executors["local"] = NewLocalExecutor("chap.sh")
+	// job manager without workers keeps submitted jobs in the queue
+	manager := jobManager
+	jobManager = newJobManager(1)
+	defer func() {
+		jobManager = manager
+		delete(executors, "local")
+		Config.UserDir = ""
+		Config.WorkflowsRoot = ""
+		Config.Quota = ""
+	}()
+	testWorkflow(t, "wflow", "pipeline:\n  - common.PrintProcessor\n")
+	testWorkflow(t, "other", "pipeline:\n  - common.PrintProcessor\n")
+
+	req := RunRequest{User: "test", Workflow: "wflow", Module: "userprocessor", Processor: "UserProcessor", Executor: "local"}
+	if _, err := submitRun(req); err != nil {
+		t.Fatal(err)
+	}
+	bad := req
+	bad.Executor = "unknown"
+	override := req
+	override.Overrides = []Override{{Item: "common.Unknown", Key: "key", Value: 1}}
+	other := req
+	other.Workflow = "other"
+	for _, rec := range []struct {
+		req  RunRequest
+		err  error
+		code int
+	}{
+		{bad, ErrInvalidRun, http.StatusBadRequest},
+		{override, ErrInvalidRun, http.StatusBadRequest},
+		{req, ErrActiveJob, http.StatusConflict},
+		{other, ErrQueueFull, http.StatusServiceUnavailable},
+	} {
+		_, err := submitRun(rec.req)
+		if !errors.Is(err, rec.err) {
+			t.Errorf("wrong error %v, expect %v", err, rec.err)
+		}
+		if code := submitErrorCode(err, http.StatusInternalServerError); code != rec.code {
+			t.Errorf("wrong code %d of error %v, expect %d", code, err, rec.code)
+		}
+	}
+
+	// user area exceeds its quota
+	Config.Quota = "1"
+	os.MkdirAll(filepath.Join(Config.UserDir, "test"), 0755)
+	os.WriteFile(filepath.Join(Config.UserDir, "test", "data.txt"), []byte("data"), 0644)
+	_, err := submitRun(other)
+	if !errors.Is(err, ErrQuotaExceeded) || submitErrorCode(err, http.StatusInternalServerError) != http.StatusInsufficientStorage {
+		t.Errorf("wrong error of exceeded quota %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+// limits module provides resource limits of CHAP jobs
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sigXCPU represents SIGXCPU signal number which is sent to the process
+// when it exceeds its CPU time limit
+const sigXCPU = 24
+
+// Limits defines resource limits of CHAP job
+type Limits struct {
+	Timeout   string `json:"timeout" yaml:"timeout"`       // wall-clock timeout, e.g. 2h
+	CPUTime   string `json:"cpu_time" yaml:"cpu_time"`     // CPU time, e.g. 1h30m
+	Memory    string `json:"memory" yaml:"memory"`         // address space size, e.g. 4G
+	MaxOutput string `json:"max_output" yaml:"max_output"` // max size of job outputs, e.g. 10G
+}
+
+// Merge returns new limits where non-empty values of given limits
+// override values of current ones
+func (l Limits) Merge(o Limits) Limits {
+	if o.Timeout != "" {
+		l.Timeout = o.Timeout
+	}
+	if o.CPUTime != "" {
+		l.CPUTime = o.CPUTime
+	}
+	if o.Memory != "" {
+		l.Memory = o.Memory
+	}
+	if o.MaxOutput != "" {
+		l.MaxOutput = o.MaxOutput
+	}
+	return l
+}
+
+// Validate checks that limits have proper format
+func (l Limits) Validate() error {
+	for _, val := range []string{l.Timeout, l.CPUTime} {
+		if _, err := parseDuration(val); err != nil {
+			return err
+		}
+	}
+	for _, val := range []string{l.Memory, l.MaxOutput} {
+		if _, err := parseSize(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimeoutDuration returns wall-clock timeout of the job
+func (l Limits) TimeoutDuration() time.Duration {
+	d, _ := parseDuration(l.Timeout)
+	return d
+}
+
+// CPUSeconds returns CPU time limit in seconds
+func (l Limits) CPUSeconds() int64 {
+	d, _ := parseDuration(l.CPUTime)
+	return int64(d.Seconds())
+}
+
+// MemoryBytes returns memory limit in bytes
+func (l Limits) MemoryBytes() int64 {
+	size, _ := parseSize(l.Memory)
+	return size
+}
+
+// MaxOutputBytes returns max output size in bytes
+func (l Limits) MaxOutputBytes() int64 {
+	size, _ := parseSize(l.MaxOutput)
+	return size
+}
+
+// helper function to obtain limits of the workflow, the workflow limits
+// declared in its chap.yaml override server defaults
+func workflowLimits(workflow string) Limits {
+	limits := Config.Limits
+	if w, ok := findWorkflow(workflow); ok {
+		limits = limits.Merge(w.Limits)
+	}
+	return limits
+}
+
+// helper function to parse duration, empty value means no limit
+func parseDuration(val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s', error %v", val, err)
+	}
+	return d, nil
+}
+
+// helper function to parse size with optional K, M, G, T suffix,
+// empty value means no limit
+func parseSize(val string) (int64, error) {
+	if val == "" {
+		return 0, nil
+	}
+	units := map[string]int64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(val)), "B")
+	multiplier := int64(1)
+	for suffix, unit := range units {
+		if strings.HasSuffix(str, suffix) {
+			multiplier = unit
+			str = strings.TrimSuffix(str, suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(str, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", val)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// helper function to construct ulimit command for given limits
+func ulimitCommand(limits Limits) string {
+	var cmds []string
+	if sec := limits.CPUSeconds(); sec > 0 {
+		cmds = append(cmds, fmt.Sprintf("ulimit -t %d", sec))
+	}
+	if size := limits.MemoryBytes(); size > 0 {
+		// ulimit -v uses kilobytes
+		cmds = append(cmds, fmt.Sprintf("ulimit -v %d", size/1024))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// helper function to format duration as HH:MM:SS used by batch systems
+func batchDuration(d time.Duration) string {
+	sec := int64(d.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", sec/3600, (sec%3600)/60, sec%60)
+}
+
+// helper function to calculate size of directory
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// helper function to check if running job exceeds its limits, it returns
+// reason of limit violation or empty string
+func checkLimits(job Job) string {
+	if timeout := job.Limits.TimeoutDuration(); timeout > 0 && time.Since(job.AttemptStart()) > timeout {
+		return fmt.Sprintf("wall-clock timeout of %s is exceeded", job.Limits.Timeout)
+	}
+	if max := job.Limits.MaxOutputBytes(); max > 0 && dirSize(job.Dir) > max {
+		return fmt.Sprintf("max output size of %s is exceeded", job.Limits.MaxOutput)
+	}
+	return ""
+}
+
+// helper function to identify which limit caused failure of the job
+func limitReason(job Job, status ExecStatus) string {
+	if job.Limits.CPUTime != "" && status.ExitCode == 128+sigXCPU {
+		return fmt.Sprintf("CPU time limit of %s is exceeded", job.Limits.CPUTime)
+	}
+	if job.Limits.Memory != "" && status.ExitCode != 0 {
+		data, err := os.ReadFile(filepath.Join(job.Dir, "chap.log"))
+		if err == nil {
+			content := string(data)
+			if strings.Contains(content, "MemoryError") || strings.Contains(content, "Cannot allocate memory") {
+				return fmt.Sprintf("memory limit of %s is exceeded", job.Limits.Memory)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseSize tests parsing of sizes with unit suffixes
+func TestParseSize(t *testing.T) {
+	for val, expect := range map[string]int64{
+		"":     0,
+		"512":  512,
+		"1K":   1024,
+		"4G":   4 << 30,
+		"4gb":  4 << 30,
+		"1.5M": 3 << 19,
+		" 2T ": 2 << 40,
+	} {
+		size, err := parseSize(val)
+		if err != nil || size != expect {
+			t.Errorf("parseSize(%q) = %d, error %v, expect %d", val, size, err, expect)
+		}
+	}
+	for _, val := range []string{"4X", "G", "-1K", "lots"} {
+		if _, err := parseSize(val); err == nil {
+			t.Errorf("invalid size %q is accepted", val)
+		}
+	}
+}
+
+// TestParseDuration tests parsing of durations
+func TestParseDuration(t *testing.T) {
+	if d, err := parseDuration(""); err != nil || d != 0 {
+		t.Errorf("empty duration should mean no limit, got %v error %v", d, err)
+	}
+	if d, err := parseDuration("1h30m"); err != nil || d != 90*time.Minute {
+		t.Errorf("wrong duration %v, error %v", d, err)
+	}
+	for _, val := range []string{"2 hours", "10", "1x"} {
+		if _, err := parseDuration(val); err == nil {
+			t.Errorf("invalid duration %q is accepted", val)
+		}
+	}
+}
+
+// TestUlimitCommand tests ulimit command of job limits
+func TestUlimitCommand(t *testing.T) {
+	if cmd := ulimitCommand(Limits{Timeout: "1h", MaxOutput: "1G"}); cmd != "" {
+		t.Errorf("limits without CPU time and memory produce ulimit command %q", cmd)
+	}
+	cmd := ulimitCommand(Limits{CPUTime: "1h30m", Memory: "4G"})
+	if cmd != "ulimit -t 5400 && ulimit -v 4194304" {
+		t.Errorf("wrong ulimit command %q", cmd)
+	}
+}
+
+// TestCheckLimits tests detection of wall-clock timeout and output size
+// violations of running job
+func TestCheckLimits(t *testing.T) {
+	job := Job{Dir: t.TempDir(), StartTime: time.Now()}
+	if reason := checkLimits(job); reason != "" {
+		t.Errorf("job without limits violates them: %s", reason)
+	}
+	job.Limits = Limits{Timeout: "1h", MaxOutput: "1K"}
+	if reason := checkLimits(job); reason != "" {
+		t.Errorf("job within its limits violates them: %s", reason)
+	}
+	if err := os.WriteFile(filepath.Join(job.Dir, "out.dat"), make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if reason := checkLimits(job); !strings.Contains(reason, "max output size of 1K") {
+		t.Errorf("wrong reason of output size violation %q", reason)
+	}
+	// timeout is counted from the start of current attempt
+	job.Limits.MaxOutput = ""
+	job.StartTime = time.Now().Add(-2 * time.Hour)
+	if reason := checkLimits(job); !strings.Contains(reason, "wall-clock timeout of 1h") {
+		t.Errorf("wrong reason of timeout violation %q", reason)
+	}
+	job.Attempts = []Attempt{{Number: 2, StartTime: time.Now()}}
+	if reason := checkLimits(job); reason != "" {
+		t.Errorf("new attempt violates timeout: %s", reason)
+	}
+}
+
+// TestValidateJobLimits tests that job with invalid workflow limits is rejected
+func TestValidateJobLimits(t *testing.T) {
+	job := &Job{
+		Workflow: "wflow",
+		Limits:   Config.Limits.Merge(Limits{Memory: "16X"}),
+		Config:   "pipeline:\n  - common.PrintProcessor\n",
+	}
+	if err := validateJob(job); err == nil || !strings.Contains(err.Error(), "invalid limits of workflow wflow") {
+		t.Errorf("invalid workflow limits are accepted, error %v", err)
+	}
+}
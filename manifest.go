@@ -0,0 +1,138 @@
+package main
+
+// manifest module provides manifest of files produced by CHAP run
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestFile defines name of manifest file in run directory
+const manifestFile = "manifest.json"
+
+// ManifestFile represents file produced by CHAP run
+type ManifestFile struct {
+	Path      string    `json:"path"`       // path relative to run directory
+	Size      int64     `json:"size"`       // file size in bytes
+	ModTime   time.Time `json:"mtime"`      // file modification time
+	MediaType string    `json:"media_type"` // file media type
+	SHA256    string    `json:"sha256"`     // SHA-256 checksum of the file
+	URL       string    `json:"url"`        // URL path of the file
+}
+
+// Manifest represents manifest of CHAP run
+type Manifest struct {
+	ID       string         `json:"id"`       // run identifier
+	User     string         `json:"user"`     // user name
+	Workflow string         `json:"workflow"` // workflow name
+	Status   string         `json:"status"`   // run status
+	Created  time.Time      `json:"created"`  // manifest creation time
+	Files    []ManifestFile `json:"files"`    // files of the run
+}
+
+// helper function to calculate SHA-256 checksum of the file and detect
+// its media type
+func fileChecksum(fname string) (string, string, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+	mediaType := mime.TypeByExtension(filepath.Ext(fname))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(head[:n])
+	}
+	hash := sha256.New()
+	hash.Write(head[:n])
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), mediaType, nil
+}
+
+// helper function to scan run directory of the job and write its manifest
+func writeManifest(job Job) (Manifest, error) {
+	manifest := Manifest{
+		ID:       job.ID,
+		User:     job.User,
+		Workflow: job.Workflow,
+		Status:   job.Status,
+		Created:  time.Now(),
+	}
+	base := runURL(job.User, job.Workflow, job.ID)
+	err := filepath.Walk(job.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(job.Dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == manifestFile {
+			return nil
+		}
+		sum, mediaType, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Path:      filepath.ToSlash(rel),
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			MediaType: mediaType,
+			SHA256:    sum,
+			URL:       fmt.Sprintf("%s/%s", base, escapePath(filepath.ToSlash(rel))),
+		})
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return manifest, err
+	}
+	err = os.WriteFile(filepath.Join(job.Dir, manifestFile), data, 0644)
+	return manifest, err
+}
+
+// helper function to escape every segment of relative URL path
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// helper function to read manifest from run directory
+func readManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestManifest tests checksums of run files and manifest round-trip
+func TestManifest(t *testing.T) {
+	Config.Base = "/chapaas"
+	defer func() { Config.Base = "" }()
+	job := Job{ID: "20260101-000001-abcd", User: "test", Workflow: "wflow", Status: JobFinished, Dir: t.TempDir()}
+	files := map[string]string{
+		"chap.log":           "CHAP done\n",
+		"out/result.json":    `{"value": 1}`,
+		"out/scan #1?.txt":   "scan",
+		"data/empty.dat":     "",
+		"data/nested/ab.csv": "a,b\n1,2\n",
+	}
+	for name, content := range files {
+		fname := filepath.Join(job.Dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fname), 0755)
+		if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, err := writeManifest(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != len(files) {
+		t.Fatalf("wrong number of manifest files %d", len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		content, ok := files[f.Path]
+		if !ok {
+			t.Errorf("unknown manifest file %s", f.Path)
+			continue
+		}
+		sum := sha256.Sum256([]byte(content))
+		if f.SHA256 != hex.EncodeToString(sum[:]) || f.Size != int64(len(content)) {
+			t.Errorf("wrong checksum or size of %s: %+v", f.Path, f)
+		}
+	}
+	byPath := make(map[string]ManifestFile)
+	for _, f := range manifest.Files {
+		byPath[f.Path] = f
+	}
+	if f := byPath["out/result.json"]; f.MediaType != "application/json" {
+		t.Errorf("wrong media type %s", f.MediaType)
+	}
+	if f := byPath["out/scan #1?.txt"]; f.URL != "/chapaas/users/test/wflow/runs/20260101-000001-abcd/out/scan%20%231%3F.txt" {
+		t.Errorf("wrong file URL %s", f.URL)
+	}
+
+	// manifest is read back from run directory and does not list itself
+	rec, err := readManifest(job.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ID != job.ID || rec.Status != JobFinished || len(rec.Files) != len(manifest.Files) {
+		t.Fatalf("wrong manifest %+v", rec)
+	}
+	for i, f := range rec.Files {
+		if f.Path != manifest.Files[i].Path || f.SHA256 != manifest.Files[i].SHA256 || !f.ModTime.Equal(manifest.Files[i].ModTime) {
+			t.Errorf("manifest file %s is not preserved: %+v", f.Path, f)
+		}
+	}
+	if manifest, err = writeManifest(job); err != nil || len(manifest.Files) != len(files) {
+		t.Errorf("manifest lists itself %d, error %v", len(manifest.Files), err)
+	}
+}
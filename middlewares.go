@@ -103,6 +103,14 @@ func (rw *responseWriter) WriteHeader(code int) {
 	return
 }
 
+// Flush implements http.Flusher interface which is required for streaming
+// responses, e.g. server-sent events
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // mux (http.Handler) logging middleware to log the incoming HTTP request and its duration.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +31,7 @@ type Cell struct {
 	ExecutionCounter int
 	Id               string
 	Source           string
+	Metadata         map[string]any
 }
 
 // Notebook represents jupyter notebook object
@@ -0,0 +1,88 @@
+package main
+
+// override module provides user parameter overrides of CHAP pipeline items,
+// the override addresses pipeline item either by its position or by its
+// class and sets (nested) argument of the item, e.g.
+//
+//	[{"index": 0, "key": "filename", "value": "scan.yaml"},
+//	 {"item": "common.IntegrationProcessor", "key": "config.radial_min", "value": 0.1}]
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Override represents user override of pipeline item argument
+type Override struct {
+	Item  string `json:"item,omitempty"`  // class of pipeline items, e.g. common.YAMLReader
+	Index *int   `json:"index,omitempty"` // position of pipeline item starting from zero
+	Key   string `json:"key"`             // argument of the item, nested keys are separated by dot
+	Value any    `json:"value"`           // value of the argument
+}
+
+// Name returns name of overridden argument
+func (o Override) Name() string {
+	if o.Index != nil {
+		return fmt.Sprintf("%d.%s", *o.Index, o.Key)
+	}
+	return fmt.Sprintf("%s.%s", o.Item, o.Key)
+}
+
+// Validate checks the override
+func (o Override) Validate() error {
+	if (o.Item == "") == (o.Index == nil) {
+		return errors.New("override should specify either pipeline item class or its index")
+	}
+	if o.Key == "" {
+		return fmt.Errorf("override %s does not specify argument", o.Name())
+	}
+	return nil
+}
+
+// helper function to parse JSON list of overrides
+func parseOverrides(data string) ([]Override, error) {
+	var overrides []Override
+	if data == "" {
+		return overrides, nil
+	}
+	if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+		return nil, fmt.Errorf("unable to parse overrides, error %v", err)
+	}
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// helper function to merge user overrides into CHAP configuration, the
+// overrides are applied in given order
+func mergeOverrides(config string, overrides []Override) (string, error) {
+	if len(overrides) == 0 {
+		return config, nil
+	}
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		return "", err
+	}
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			return "", err
+		}
+		if o.Index != nil {
+			err = pipeline.SetItemArg(*o.Index, o.Key, o.Value)
+		} else {
+			err = pipeline.SetArg(o.Item, o.Key, o.Value)
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to apply override %s, error %v", o.Name(), err)
+		}
+	}
+	out, err := pipeline.Marshal()
+	return string(out), err
+}
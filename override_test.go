@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestOverrides tests merging of user overrides into CHAP configuration
+func TestOverrides(t *testing.T) {
+	config := `pipeline:
+  - common.YAMLReader:
+      filename: data.yaml
+  - common.IntegrationProcessor
+  - common.YAMLReader:
+      filename: other.yaml
+`
+	overrides, err := parseOverrides(`[
+		{"index": 2, "key": "filename", "value": "scan.yaml"},
+		{"item": "common.IntegrationProcessor", "key": "config.radial_min", "value": 0.1}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := mergeOverrides(config, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := parsePipeline([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := pipeline.Items()
+	if items[0].Args["filename"] != "data.yaml" || items[2].Args["filename"] != "scan.yaml" {
+		t.Errorf("wrong reader arguments in\n%s", out)
+	}
+	if cfg, ok := items[1].Args["config"].(map[string]any); !ok || cfg["radial_min"] != 0.1 {
+		t.Errorf("wrong processor arguments in\n%s", out)
+	}
+
+	// configuration without overrides is kept as is
+	if out, _ := mergeOverrides(config, nil); out != config {
+		t.Errorf("configuration is changed without overrides\n%s", out)
+	}
+
+	// invalid overrides
+	for _, data := range []string{
+		`[{"key": "filename", "value": "scan.yaml"}]`,
+		`[{"index": 0, "item": "common.YAMLReader", "key": "filename"}]`,
+		`[{"index": 0, "value": "scan.yaml"}]`,
+		`{"index": 0}`,
+	} {
+		if _, err := parseOverrides(data); err == nil {
+			t.Errorf("no error for invalid overrides %s", data)
+		}
+	}
+	for _, data := range []string{
+		`[{"index": 3, "key": "filename", "value": "scan.yaml"}]`,
+		`[{"item": "common.NexusReader", "key": "filename", "value": "scan.nxs"}]`,
+		`[{"index": 0, "key": "filename.name", "value": "scan.yaml"}]`,
+	} {
+		overrides, err := parseOverrides(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mergeOverrides(config, overrides); err == nil || !strings.Contains(err.Error(), "override") {
+			t.Errorf("wrong error %v for overrides %s", err, data)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+// pipeline module provides typed model of CHAP pipeline configuration,
+// the pipeline is ordered list of items where every item has class path
+// and its arguments, e.g.
+//
+//	pipeline:
+//	  - common.YAMLReader:
+//	      filename: data.yaml
+//	  - common.PrintProcessor: {}
+//
+// The model keeps YAML document nodes of configuration and therefore
+// preserves comments, styles, order of keys and other top level sections
+// when configuration is modified and written back.
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// PipelineItem represents item of CHAP pipeline
+type PipelineItem struct {
+	Class string         `json:"class"` // class path of the item, e.g. common.YAMLReader
+	Args  map[string]any `json:"args"`  // arguments of the item
+	Line  int            `json:"line"`  // line number of the item in configuration
+}
+
+// Pipeline represents CHAP pipeline configuration
+type Pipeline struct {
+	doc    *yaml.Node // YAML document of configuration
+	items  *yaml.Node // sequence node of pipeline items
+	indent int        // indentation of configuration
+}
+
+// helper function to create new empty pipeline
+func newPipeline() *Pipeline {
+	p, _ := parsePipeline([]byte("pipeline: []\n"))
+	// the block style of new pipeline is easier to read and to modify
+	p.items.Style = 0
+	return p
+}
+
+// helper function to detect indentation of YAML document, i.e. the smallest
+// indentation of its lines, the default indentation is 2 spaces
+func yamlIndent(data []byte) int {
+	indent := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if n := len(line) - len(text); n > 0 && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+	if indent < 2 {
+		return 2
+	}
+	return indent
+}
+
+// helper function to parse CHAP pipeline configuration
+func parsePipeline(data []byte) (*Pipeline, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse CHAP configuration, error %v", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("CHAP configuration should be a mapping with pipeline section")
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "pipeline" {
+			continue
+		}
+		items := root.Content[i+1]
+		if items.Kind != yaml.SequenceNode {
+			return nil, errors.New("pipeline section of CHAP configuration should be a list of items")
+		}
+		for idx, item := range items.Content {
+			if _, _, err := itemNodes(item); err != nil {
+				return nil, fmt.Errorf("invalid pipeline item %d, error %v", idx+1, err)
+			}
+		}
+		return &Pipeline{doc: &doc, items: items, indent: yamlIndent(data)}, nil
+	}
+	return nil, errors.New("CHAP configuration does not have pipeline section")
+}
+
+// helper function to get class and arguments nodes of pipeline item, the
+// item is either mapping with single class key or plain class name
+func itemNodes(item *yaml.Node) (*yaml.Node, *yaml.Node, error) {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		return item, nil, nil
+	case yaml.MappingNode:
+		if len(item.Content) != 2 || item.Content[0].Kind != yaml.ScalarNode {
+			return nil, nil, errors.New("item should have single class key")
+		}
+		return item.Content[0], item.Content[1], nil
+	}
+	return nil, nil, errors.New("item should be class name or mapping of class to its arguments")
+}
+
+// helper function to create YAML node of arbitrary value
+func valueNode(val any) (*yaml.Node, error) {
+	if n, ok := val.(*yaml.Node); ok {
+		return n, nil
+	}
+	var n yaml.Node
+	if err := n.Encode(val); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// Items returns items of the pipeline
+func (p *Pipeline) Items() []PipelineItem {
+	var items []PipelineItem
+	for _, node := range p.items.Content {
+		cls, args, _ := itemNodes(node)
+		item := PipelineItem{Class: cls.Value, Args: make(map[string]any), Line: cls.Line}
+		if args != nil && args.Kind == yaml.MappingNode {
+			args.Decode(&item.Args)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Classes returns class paths of pipeline items
+func (p *Pipeline) Classes() []string {
+	var classes []string
+	for _, item := range p.Items() {
+		classes = append(classes, item.Class)
+	}
+	return classes
+}
+
+// Find returns index of first pipeline item with given class or -1
+func (p *Pipeline) Find(class string) int {
+	for idx, node := range p.items.Content {
+		if cls, _, _ := itemNodes(node); cls.Value == class {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Insert inserts new item with given class and arguments at given position,
+// the nil arguments are represented by empty mapping
+func (p *Pipeline) Insert(idx int, class string, args any) error {
+	if idx < 0 || idx > len(p.items.Content) {
+		return fmt.Errorf("invalid position %d of pipeline item %s", idx, class)
+	}
+	if class == "" {
+		return errors.New("pipeline item should have class")
+	}
+	argsNode := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if args != nil {
+		node, err := valueNode(args)
+		if err != nil {
+			return err
+		}
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("arguments of pipeline item %s should be a mapping", class)
+		}
+		argsNode = node
+	}
+	item := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: class},
+			argsNode,
+		},
+	}
+	content := append([]*yaml.Node{}, p.items.Content[:idx]...)
+	content = append(content, item)
+	p.items.Content = append(content, p.items.Content[idx:]...)
+	return nil
+}
+
+// Append adds new item with given class and arguments to the end of the
+// pipeline
+func (p *Pipeline) Append(class string, args any) error {
+	return p.Insert(len(p.items.Content), class, args)
+}
+
+// Remove removes pipeline item at given position
+func (p *Pipeline) Remove(idx int) error {
+	if idx < 0 || idx >= len(p.items.Content) {
+		return fmt.Errorf("invalid position %d of pipeline item", idx)
+	}
+	p.items.Content = append(p.items.Content[:idx], p.items.Content[idx+1:]...)
+	return nil
+}
+
+// SetArg sets (nested) argument of all pipeline items with given class,
+// the nested keys are separated by dot
+func (p *Pipeline) SetArg(class, key string, val any) error {
+	found := false
+	for _, node := range p.items.Content {
+		if cls, _, _ := itemNodes(node); cls.Value != class {
+			continue
+		}
+		found = true
+		if err := setItemArg(node, key, val); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("pipeline does not contain item %s", class)
+	}
+	return nil
+}
+
+// SetItemArg sets (nested) argument of pipeline item at given position
+func (p *Pipeline) SetItemArg(idx int, key string, val any) error {
+	if idx < 0 || idx >= len(p.items.Content) {
+		return fmt.Errorf("invalid position %d of pipeline item", idx)
+	}
+	return setItemArg(p.items.Content[idx], key, val)
+}
+
+// helper function to set (nested) argument of pipeline item node
+func setItemArg(node *yaml.Node, key string, val any) error {
+	cls, args, _ := itemNodes(node)
+	if args == nil {
+		// plain class name is converted into mapping of its arguments
+		name := *cls
+		args = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&name, args}}
+		cls = &name
+	}
+	if err := setNode(args, strings.Split(key, "."), val); err != nil {
+		return fmt.Errorf("unable to set %s of %s, error %v", key, cls.Value, err)
+	}
+	return nil
+}
+
+// helper function to set value of (nested) key of mapping node
+func setNode(node *yaml.Node, keys []string, val any) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("'%s' is not a mapping", node.Value)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) > 1 {
+			return setNode(node.Content[i+1], keys[1:], val)
+		}
+		child, err := valueNode(val)
+		if err != nil {
+			return err
+		}
+		// keep comments of replaced value
+		child.LineComment = node.Content[i+1].LineComment
+		node.Content[i+1] = child
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(keys) == 1 {
+		var err error
+		if child, err = valueNode(val); err != nil {
+			return err
+		}
+	} else if err := setNode(child, keys[1:], val); err != nil {
+		return err
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}, child)
+	return nil
+}
+
+// Marshal returns YAML representation of the pipeline configuration
+func (p *Pipeline) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(p.indent)
+	if err := enc.Encode(p.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
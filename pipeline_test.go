@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPipeline tests round-trip and modifications of CHAP pipeline config
+func TestPipeline(t *testing.T) {
+	config := `# saxswaxs workflow
+config:
+    root: /data
+pipeline:
+    - common.YAMLReader:
+        filename: data.yaml  # input file
+    - common.IntegrationProcessor:
+        radial_min: 0.1
+    - common.PrintProcessor
+# trailing comment
+`
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes := pipeline.Classes()
+	if len(classes) != 3 || classes[0] != "common.YAMLReader" || classes[2] != "common.PrintProcessor" {
+		t.Errorf("wrong pipeline items %v", classes)
+	}
+	if args := pipeline.Items()[1].Args; args["radial_min"] != 0.1 {
+		t.Errorf("wrong arguments %v", args)
+	}
+
+	// round-trip keeps comments and other sections
+	data, err := pipeline.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, s := range []string{"# saxswaxs workflow", "# input file", "# trailing comment", "root: /data"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("round-trip lost '%s':\n%s", s, out)
+		}
+	}
+	again, err := parsePipeline([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := again.Marshal(); err != nil || string(data) != out {
+		t.Errorf("round-trip is not stable, error %v:\n%s", err, data)
+	}
+
+	// modifications of pipeline items
+	if err := pipeline.SetArg("common.IntegrationProcessor", "radial_min", 0.2); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.SetArg("common.PrintProcessor", "options.verbose", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.SetArg("common.Unknown", "key", 1); err == nil {
+		t.Error("unknown pipeline item is not reported")
+	}
+	if err := pipeline.Append("users.user.userprocessor.UserProcessor", nil); err != nil {
+		t.Fatal(err)
+	}
+	items := pipeline.Items()
+	if items[1].Args["radial_min"] != 0.2 || items[2].Args["options"].(map[string]any)["verbose"] != true {
+		t.Errorf("wrong arguments %+v", items)
+	}
+	if pipeline.Find("users.user.userprocessor.UserProcessor") != 3 {
+		t.Errorf("wrong position of appended item %v", pipeline.Classes())
+	}
+	if err := pipeline.Remove(0); err != nil || pipeline.Find("common.YAMLReader") != -1 {
+		t.Errorf("item is not removed, error %v", err)
+	}
+
+	// invalid configurations
+	for _, c := range []string{"items: []", "pipeline: {}", "pipeline:\n  - a: 1\n    b: 2\n", "[1, 2]"} {
+		if _, err := parsePipeline([]byte(c)); err == nil {
+			t.Errorf("invalid configuration is accepted: %s", c)
+		}
+	}
+}
+
+// TestPipelineStyles tests that round-trip keeps styles and indentation of
+// CHAP pipeline config
+func TestPipelineStyles(t *testing.T) {
+	config := `# flow style workflow
+config:
+    root: /data # data root
+    inputs: [a.yaml, b.yaml]
+pipeline:
+    # readers
+    - common.YAMLReader: {filename: data.yaml, schema: MapConfig}
+    - common.PrintProcessor: {}
+    - common.NexusWriter:
+        filename: out.nxs # output file
+        force: true
+`
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pipeline.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != config {
+		t.Errorf("round-trip changed configuration:\n%s", data)
+	}
+
+	// new nodes do not change styles of existing ones
+	if err := pipeline.SetArg("common.YAMLReader", "filename", "new.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.SetArg("common.PrintProcessor", "verbose", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.Append("users.user.userprocessor.UserProcessor", nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err = pipeline.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"    inputs: [a.yaml, b.yaml]\n",
+		"    - common.YAMLReader: {filename: new.yaml, schema: MapConfig}\n",
+		"    - common.PrintProcessor: {verbose: true}\n",
+		"        filename: out.nxs # output file\n",
+		"    - users.user.userprocessor.UserProcessor: {}\n",
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("modified configuration does not contain '%s':\n%s", s, data)
+		}
+	}
+
+	// items of flow sequence stay in flow style
+	pipeline, err = parsePipeline([]byte("pipeline: [common.YAMLReader, common.PrintProcessor]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.SetItemArg(0, "filename", "data.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	data, err = pipeline.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "pipeline: [{common.YAMLReader: {filename: data.yaml}}, common.PrintProcessor]\n"; string(data) != expect {
+		t.Errorf("wrong flow style configuration:\n%s", data)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+// process module provides OS specific management of CHAP processes
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"os/exec"
+)
+
+// helper function to start command in its own process group,
+// process groups are not supported on windows
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// helper function to terminate process group of the command,
+// on windows we can only kill the process itself
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+// helper function to kill process group of the command
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
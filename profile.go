@@ -0,0 +1,71 @@
+package main
+
+// profile module provides profile mode of CHAP pipelines, the pipeline
+// is executed under cProfile and its profile is converted by
+// scripts/profile.py into timing report and collapsed stacks
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// list of profile files stored in run directory
+const (
+	profileData   = "chap.prof"         // cProfile output
+	profileReport = "profile.json"      // timing report
+	profileStacks = "profile.collapsed" // flamegraph compatible collapsed stacks
+)
+
+// ProfileItem represents timing of CHAP pipeline item
+type ProfileItem struct {
+	Item    string  `json:"item"`    // pipeline item, e.g. common.YAMLReader
+	Method  string  `json:"method"`  // profiled methods of the item
+	Calls   int     `json:"calls"`   // number of calls
+	CumTime float64 `json:"cumtime"` // cumulative time in seconds
+	Percent float64 `json:"percent"` // percent of total time
+}
+
+// ProfileFunction represents timing of profiled python function
+type ProfileFunction struct {
+	Function       string  `json:"function"`        // function name
+	File           string  `json:"file"`            // file name
+	Line           int     `json:"line"`            // line number
+	Calls          int     `json:"calls"`           // number of calls
+	PrimitiveCalls int     `json:"primitive_calls"` // number of non recursive calls
+	TotTime        float64 `json:"tottime"`         // time spent in function itself
+	CumTime        float64 `json:"cumtime"`         // time spent in function and its callees
+	Percent        float64 `json:"percent"`         // percent of total time
+}
+
+// ProfileReport represents timing report of CHAP pipeline
+type ProfileReport struct {
+	Total     float64           `json:"total"`     // total time in seconds
+	Items     []ProfileItem     `json:"items"`     // timing of pipeline items
+	Functions []ProfileFunction `json:"functions"` // timing of functions
+}
+
+// helper function to read profile report from run directory
+func readProfile(dir string) (ProfileReport, error) {
+	var report ProfileReport
+	fname := filepath.Join(dir, profileReport)
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return report, fmt.Errorf("unable to read profile report, error %v", err)
+	}
+	err = json.Unmarshal(data, &report)
+	return report, err
+}
+
+// helper function to get profile script used by executors
+func profileScript() string {
+	fname := filepath.Join(Config.ScriptsDir, "profile.py")
+	if path, err := filepath.Abs(fname); err == nil {
+		return path
+	}
+	return fname
+}
@@ -0,0 +1,135 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReadProfile tests parsing of profile report of CHAP run
+func TestReadProfile(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := readProfile(dir); err == nil {
+		t.Error("missing profile report is read")
+	}
+	data := `{"total": 2.5,
+ "items": [{"item": "common.YAMLReader", "method": "read", "calls": 1, "cumtime": 0.5, "percent": 20}],
+ "functions": [{"function": "read", "file": "reader.py", "line": 10, "calls": 2,
+   "primitive_calls": 1, "tottime": 0.1, "cumtime": 0.5, "percent": 20}]}`
+	if err := os.WriteFile(filepath.Join(dir, profileReport), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := readProfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 2.5 || len(report.Items) != 1 || len(report.Functions) != 1 {
+		t.Fatalf("wrong profile report %+v", report)
+	}
+	if item := report.Items[0]; item.Item != "common.YAMLReader" || item.Method != "read" || item.Percent != 20 {
+		t.Errorf("wrong profile item %+v", item)
+	}
+	if f := report.Functions[0]; f.Line != 10 || f.PrimitiveCalls != 1 || f.TotTime != 0.1 {
+		t.Errorf("wrong profile function %+v", f)
+	}
+}
+
+// TestProfileScript tests conversion of cProfile output by profile.py
+func TestProfileScript(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	if err := exec.Command(python, "-c", "import yaml").Run(); err != nil {
+		t.Skip("python yaml module is not available")
+	}
+	dir := t.TempDir()
+	code := `import time
+
+class Worker:
+    def process(self, data):
+        time.sleep(0.05)
+        return data
+
+Worker().process(1)
+`
+	config := "pipeline:\n  - work.Worker:\n      count: 1\n"
+	os.WriteFile(filepath.Join(dir, "work.py"), []byte(code), 0644)
+	os.WriteFile(filepath.Join(dir, "chap.yaml"), []byte(config), 0644)
+	cmd := exec.Command(python, "-m", "cProfile", "-o", profileData, "work.py")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("unable to profile code, error %v, output %s", err, out)
+	}
+	script, _ := filepath.Abs(filepath.Join("scripts", "profile.py"))
+	cmd = exec.Command(python, script, profileData, "chap.yaml")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("profile.py failed with error %v, output %s", err, out)
+	}
+	report, err := readProfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total < 0.05 || len(report.Functions) == 0 {
+		t.Errorf("wrong profile total %v or functions %d", report.Total, len(report.Functions))
+	}
+	if len(report.Items) != 1 {
+		t.Fatalf("wrong profile items %+v", report.Items)
+	}
+	if item := report.Items[0]; item.Item != "work.Worker" || item.Method != "process" || item.Calls != 1 || item.CumTime < 0.05 {
+		t.Errorf("wrong profile item %+v", item)
+	}
+	stacks, err := os.ReadFile(filepath.Join(dir, profileStacks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stacks), "process (") {
+		t.Errorf("collapsed stacks do not contain pipeline item method:\n%s", stacks)
+	}
+}
+
+// TestProfileMode tests that CHAP pipeline is executed in profile mode by
+// local and batch executors
+func TestProfileMode(t *testing.T) {
+	Config.WorkflowsRoot = t.TempDir()
+	defer func() { Config.WorkflowsRoot = "" }()
+	wdir := filepath.Join(Config.WorkflowsRoot, "wflow")
+	os.MkdirAll(wdir, 0755)
+	os.WriteFile(filepath.Join(wdir, "conda.yml"), []byte("name: wflow\n"), 0644)
+
+	e := NewLocalExecutor(fakeChap(t, `echo "profile=$CHAP_PROFILE" > chap.log`))
+	for _, profile := range []bool{false, true} {
+		job := *testJob("wflow")
+		job.Dir = t.TempDir()
+		job.Profile = profile
+		if _, err := e.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+		waitLocal(t, e, job)
+		data, _ := os.ReadFile(filepath.Join(job.Dir, "chap.log"))
+		expect := "profile=\n"
+		if profile {
+			expect = "profile=1\n"
+		}
+		if string(data) != expect {
+			t.Errorf("wrong environment of CHAP process %q, expect %q", data, expect)
+		}
+
+		// batch script runs CHAP under profiler and converts its profile
+		fname, err := prepareBatchJob(job, "gridengine.tmpl", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ = os.ReadFile(fname)
+		for _, line := range []string{"python -m cProfile -o chap.prof", profileScript() + " chap.prof"} {
+			if strings.Contains(string(data), line) != profile {
+				t.Errorf("profile=%v: batch script contains '%s' %v", profile, line, !profile)
+			}
+		}
+	}
+}
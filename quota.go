@@ -0,0 +1,256 @@
+package main
+
+// quota module provides disk quotas of user areas and garbage collection
+// of old tar-balls and runs
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WorkflowUsage represents disk usage of user's workflow
+type WorkflowUsage struct {
+	Workflow string `json:"workflow"` // workflow name
+	Size     int64  `json:"size"`     // size of workflow area in bytes
+	Runs     int    `json:"runs"`     // number of runs of the workflow
+}
+
+// SizeString returns human readable size of workflow area
+func (w WorkflowUsage) SizeString() string {
+	return formatSize(w.Size)
+}
+
+// Usage represents disk usage of user area
+type Usage struct {
+	User      string          `json:"user"`      // user name
+	Total     int64           `json:"total"`     // total size of user area in bytes
+	Quota     int64           `json:"quota"`     // user quota in bytes, 0 means no quota
+	Tarballs  int64           `json:"tarballs"`  // size of tar-balls in bytes
+	Other     int64           `json:"other"`     // size of other files in bytes
+	Workflows []WorkflowUsage `json:"workflows"` // usage of user's workflows
+}
+
+// TotalString returns human readable size of user area
+func (u Usage) TotalString() string {
+	return formatSize(u.Total)
+}
+
+// QuotaString returns human readable quota of the user
+func (u Usage) QuotaString() string {
+	if u.Quota <= 0 {
+		return "unlimited"
+	}
+	return formatSize(u.Quota)
+}
+
+// TarballsString returns human readable size of tar-balls
+func (u Usage) TarballsString() string {
+	return formatSize(u.Tarballs)
+}
+
+// OtherString returns human readable size of other files
+func (u Usage) OtherString() string {
+	return formatSize(u.Other)
+}
+
+// Percent returns percentage of used quota
+func (u Usage) Percent() int {
+	if u.Quota <= 0 {
+		return 0
+	}
+	return int(u.Total * 100 / u.Quota)
+}
+
+// helper function to format size in bytes with K, M, G, T suffix
+func formatSize(size int64) string {
+	units := []string{"K", "M", "G", "T"}
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	val := float64(size)
+	var unit string
+	for _, unit = range units {
+		val /= 1024
+		if val < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f%s", val, unit)
+}
+
+// helper function to check if file is tar-ball produced by ChapTarHandler
+func isTarball(name string) bool {
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz")
+}
+
+// helper function to obtain quota of the user in bytes, 0 means no quota
+func userQuota(user string) int64 {
+	val := Config.Quota
+	if q, ok := Config.UserQuotas[user]; ok {
+		val = q
+	}
+	size, err := parseSize(val)
+	if err != nil {
+		log.Printf("ERROR: invalid quota of user %s, error %v", user, err)
+		return 0
+	}
+	return size
+}
+
+// helper function to calculate disk usage of user area
+func userUsage(user string) (Usage, error) {
+	usage := Usage{User: user, Quota: userQuota(user)}
+	entries, err := os.ReadDir(filepath.Join(Config.UserDir, user))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return usage, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(Config.UserDir, user, entry.Name())
+		if entry.IsDir() {
+			rec := WorkflowUsage{Workflow: entry.Name(), Size: dirSize(path)}
+			if runs, err := os.ReadDir(filepath.Join(path, "runs")); err == nil {
+				rec.Runs = len(runs)
+			}
+			usage.Workflows = append(usage.Workflows, rec)
+			usage.Total += rec.Size
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if isTarball(entry.Name()) {
+			usage.Tarballs += info.Size()
+		} else {
+			usage.Other += info.Size()
+		}
+		usage.Total += info.Size()
+	}
+	sort.Slice(usage.Workflows, func(i, j int) bool {
+		return usage.Workflows[i].Size > usage.Workflows[j].Size
+	})
+	return usage, nil
+}
+
+// ErrQuotaExceeded is returned when user exceeds its disk quota
+var ErrQuotaExceeded = errors.New("disk quota is exceeded")
+
+// helper function to check that user does not exceed its disk quota
+func checkQuota(user string) error {
+	quota := userQuota(user)
+	if quota <= 0 {
+		return nil
+	}
+	usage, err := userUsage(user)
+	if err != nil {
+		return err
+	}
+	if usage.Total >= quota {
+		return fmt.Errorf("%w, used %s of %s, please remove old runs or tar-balls",
+			ErrQuotaExceeded, formatSize(usage.Total), formatSize(quota))
+	}
+	return nil
+}
+
+// helper function to remove tar-balls and unpinned runs which are older
+// than retention period, the runs used by workflow chains are kept
+func collectGarbage(retention time.Duration) {
+	cutoff := time.Now().Add(-retention)
+	users, err := os.ReadDir(Config.UserDir)
+	if err != nil {
+		log.Println("ERROR: unable to read user area", err)
+		return
+	}
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(Config.UserDir, user.Name()))
+		if err != nil {
+			log.Printf("ERROR: unable to read area of user %s, error %v", user.Name(), err)
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || !isTarball(entry.Name()) {
+				continue
+			}
+			if info.ModTime().After(cutoff) {
+				continue
+			}
+			fname := filepath.Join(Config.UserDir, user.Name(), entry.Name())
+			if err := os.Remove(fname); err != nil {
+				log.Printf("ERROR: unable to remove %s, error %v", fname, err)
+				continue
+			}
+			log.Printf("tar-ball %s is removed", fname)
+		}
+	}
+	if storage == nil {
+		return
+	}
+	names, err := storage.Buckets(runsBucket)
+	if err != nil {
+		log.Println("ERROR: unable to get users of run history", err)
+		return
+	}
+	for _, user := range names {
+		records, err := userRuns(user)
+		if err != nil {
+			log.Printf("ERROR: unable to get runs of user %s, error %v", user, err)
+			continue
+		}
+		chained, err := chainRuns(user)
+		if err != nil {
+			log.Printf("ERROR: unable to get chains of user %s, error %v", user, err)
+			continue
+		}
+		for _, rec := range records {
+			if rec.Pinned || rec.Status == JobQueued || rec.Status == JobRunning {
+				continue
+			}
+			if _, ok := chained[rec.ID]; ok {
+				continue
+			}
+			end := rec.EndTime
+			if end.IsZero() {
+				end = rec.SubmitTime
+			}
+			if end.After(cutoff) {
+				continue
+			}
+			if err := deleteRun(user, rec.ID); err != nil {
+				log.Printf("ERROR: unable to collect run %s, error %v", rec.ID, err)
+			}
+		}
+	}
+}
+
+// helper function to start garbage collector of user areas, it is
+// enabled when retention period is configured
+func initCollector() {
+	retention, _ := parseDuration(Config.Retention)
+	if retention <= 0 {
+		return
+	}
+	interval, _ := parseDuration(Config.GCInterval)
+	go func() {
+		for {
+			collectGarbage(retention)
+			time.Sleep(interval)
+		}
+	}()
+	log.Printf("garbage collector started, retention %s interval %s", retention, interval)
+}
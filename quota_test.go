@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestUserUsage tests disk usage, quota and garbage collection of user area
+func TestUserUsage(t *testing.T) {
+	Config.UserDir = t.TempDir()
+	Config.Quota = "1K"
+	Config.UserQuotas = map[string]string{"admin": ""}
+	defer func() {
+		Config.Quota = ""
+		Config.UserQuotas = nil
+	}()
+	rdir := filepath.Join(Config.UserDir, "user", "wflow", "runs", "1")
+	if err := os.MkdirAll(rdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]int{
+		filepath.Join(rdir, "chap.log"):                       600,
+		filepath.Join(Config.UserDir, "user", "wflow.tar.gz"): 300,
+		filepath.Join(Config.UserDir, "user", "notes.txt"):    100,
+	}
+	for fname, size := range files {
+		if err := os.WriteFile(fname, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, err := userUsage("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Total != 1000 || usage.Tarballs != 300 || usage.Other != 100 || usage.Quota != 1024 {
+		t.Errorf("wrong usage %+v", usage)
+	}
+	if len(usage.Workflows) != 1 || usage.Workflows[0].Size != 600 || usage.Workflows[0].Runs != 1 {
+		t.Errorf("wrong workflow usage %+v", usage.Workflows)
+	}
+	if err := checkQuota("user"); err != nil {
+		t.Errorf("quota should not be exceeded, error %v", err)
+	}
+	if userQuota("admin") != 0 {
+		t.Error("admin should not have quota")
+	}
+
+	// tar-ball older than retention period is removed
+	old := time.Now().Add(-2 * time.Hour)
+	tarball := filepath.Join(Config.UserDir, "user", "wflow.tar.gz")
+	os.Chtimes(tarball, old, old)
+	collectGarbage(time.Hour)
+	if _, err := os.Stat(tarball); !os.IsNotExist(err) {
+		t.Error("old tar-ball is not removed")
+	}
+	if _, err := os.Stat(filepath.Join(Config.UserDir, "user", "notes.txt")); err != nil {
+		t.Error("user file should not be removed")
+	}
+}
+
+// TestCollectRuns tests that garbage collector keeps pinned runs and runs
+// used by workflow chains
+func TestCollectRuns(t *testing.T) {
+	Config.UserDir = t.TempDir()
+	defer func() { Config.UserDir = "" }()
+	setupStorage(t)
+	old := testRun(t, "wflow", 1)
+	pinned := testRun(t, "wflow", 2)
+	chained := testRun(t, "wflow", 3)
+	if err := pinRun("test", pinned.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{ID: "chain", User: "test", Status: JobFinished, Stages: []ChainStageRun{
+		{ChainStage: ChainStage{Name: "reduce", Workflow: "wflow"}, JobID: chained.ID, Status: JobFinished},
+	}}
+	if err := saveChain(chain); err != nil {
+		t.Fatal(err)
+	}
+	collectGarbage(time.Hour)
+	if _, err := getRun("test", old.ID); err == nil {
+		t.Error("old run is not removed")
+	}
+	for _, job := range []Job{pinned, chained} {
+		if _, err := getRun("test", job.ID); err != nil {
+			t.Errorf("run %s is removed", job.ID)
+		}
+		if _, err := os.Stat(job.Dir); err != nil {
+			t.Errorf("directory of run %s is removed", job.ID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+// retry module provides retry policy of failed CHAP runs
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// RetryPolicy defines how failed runs of the workflow are retried
+type RetryPolicy struct {
+	MaxAttempts   int      `json:"max_attempts" yaml:"max_attempts"`     // max number of attempts including the first one
+	Backoff       string   `json:"backoff" yaml:"backoff"`               // delay before first retry, e.g. 30s
+	BackoffFactor float64  `json:"backoff_factor" yaml:"backoff_factor"` // multiplier of delay for subsequent retries, default 2
+	ExitCodes     []int    `json:"exit_codes" yaml:"exit_codes"`         // exit codes which are retryable
+	LogPatterns   []string `json:"log_patterns" yaml:"log_patterns"`     // regular expressions of retryable errors in CHAP log
+}
+
+// Attempt represents single attempt of CHAP run
+type Attempt struct {
+	Number    int       `json:"number"`     // attempt number starting from 1
+	ExecID    string    `json:"exec_id"`    // job id within executor
+	StartTime time.Time `json:"start_time"` // attempt start time
+	EndTime   time.Time `json:"end_time"`   // attempt end time
+	Status    string    `json:"status"`     // attempt status
+	ExitCode  int       `json:"exit_code"`  // exit code of attempt
+	Error     string    `json:"error"`      // error of attempt
+	Log       string    `json:"log"`        // name of CHAP log of attempt
+}
+
+// Enabled returns true if policy allows retries
+func (p RetryPolicy) Enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// Delay returns delay before given retry, the first retry is 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay, _ := parseDuration(p.Backoff)
+	factor := p.BackoffFactor
+	if factor <= 0 {
+		factor = 2
+	}
+	return time.Duration(float64(delay) * math.Pow(factor, float64(retry-1)))
+}
+
+// Retryable checks if failure with given exit code and error message is
+// retryable, the error message and CHAP log are matched against log patterns
+func (p RetryPolicy) Retryable(code int, msg, logContent string) bool {
+	for _, c := range p.ExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	for _, pat := range p.LogPatterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			log.Printf("ERROR: invalid retry log pattern '%s', error %v", pat, err)
+			continue
+		}
+		if re.MatchString(msg) || re.MatchString(logContent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that retry policy has proper format
+func (p RetryPolicy) Validate() error {
+	if _, err := parseDuration(p.Backoff); err != nil {
+		return err
+	}
+	for _, pat := range p.LogPatterns {
+		if _, err := regexp.Compile(pat); err != nil {
+			return fmt.Errorf("invalid log pattern '%s', error %v", pat, err)
+		}
+	}
+	return nil
+}
+
+// helper function to obtain retry policy of the workflow
+func workflowRetry(workflow string) RetryPolicy {
+	if w, ok := findWorkflow(workflow); ok {
+		if err := w.Retry.Validate(); err != nil {
+			log.Printf("ERROR: workflow %s has invalid retry policy, error %v", workflow, err)
+			return RetryPolicy{}
+		}
+		return w.Retry
+	}
+	return RetryPolicy{}
+}
+
+// helper function to archive CHAP log of failed attempt, it returns
+// name of archived log
+func archiveAttemptLog(job Job, number int) string {
+	name := fmt.Sprintf("chap.attempt-%d.log", number)
+	src := filepath.Join(job.Dir, "chap.log")
+	if err := os.Rename(src, filepath.Join(job.Dir, name)); err != nil {
+		log.Printf("ERROR: unable to archive log of job %s attempt %d, error %v", job.ID, number, err)
+		return "chap.log"
+	}
+	return name
+}
+
+// helper function to read CHAP log of the job
+func readJobLog(job Job) string {
+	data, err := os.ReadFile(filepath.Join(job.Dir, "chap.log"))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,134 @@
+//go:build !windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetryPolicy tests retryable failures and backoff delays of retry policy
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     "30s",
+		ExitCodes:   []int{75},
+		LogPatterns: []string{`Connection (refused|reset)`},
+	}
+	if !policy.Enabled() || (RetryPolicy{MaxAttempts: 1}).Enabled() {
+		t.Error("wrong policy state")
+	}
+	for _, rec := range []struct {
+		code   int
+		msg    string
+		log    string
+		expect bool
+	}{
+		{75, "", "", true},
+		{1, "", "", false},
+		{1, "", "OSError: Connection refused by host", true},
+		{1, "Connection reset", "", true},
+		{1, "Permission denied", "Traceback", false},
+	} {
+		if got := policy.Retryable(rec.code, rec.msg, rec.log); got != rec.expect {
+			t.Errorf("Retryable(%d, %q, %q) = %v, expect %v", rec.code, rec.msg, rec.log, got, rec.expect)
+		}
+	}
+	// invalid log patterns are skipped
+	if (RetryPolicy{LogPatterns: []string{"("}}).Retryable(1, "(", "(") {
+		t.Error("invalid log pattern matches failure")
+	}
+
+	// delay grows by backoff factor, default factor is 2
+	for retry, expect := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute} {
+		if d := policy.Delay(retry); d != expect {
+			t.Errorf("Delay(%d) = %v, expect %v", retry, d, expect)
+		}
+	}
+	policy.BackoffFactor = 1.5
+	if d := policy.Delay(3); d != 67500*time.Millisecond {
+		t.Errorf("wrong delay %v with backoff factor", d)
+	}
+	if d := (RetryPolicy{}).Delay(2); d != 0 {
+		t.Errorf("policy without backoff has delay %v", d)
+	}
+	if err := (RetryPolicy{Backoff: "soon"}).Validate(); err == nil {
+		t.Error("invalid backoff is accepted")
+	}
+}
+
+// TestJobRetry tests that job waiting for its next attempt does not occupy
+// worker of job manager
+func TestJobRetry(t *testing.T) {
+	script := fakeChap(t, `if [ "$1" == "flaky" ] && [ ! -f attempted ]; then
+    touch attempted
+    echo "first attempt failed" > chap.log
+    exit 75
+fi
+if [ "$1" == "broken" ]; then
+    exit 75
+fi
+echo "CHAP done" > chap.log`)
+	setupJobManager(t, script)
+	policy := RetryPolicy{MaxAttempts: 2, Backoff: "2s", ExitCodes: []int{75}}
+
+	flaky := testJob("flaky")
+	flaky.Retry = policy
+	if err := jobManager.Submit(flaky); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the first attempt to fail and submit another job which
+	// should run while flaky job waits for its next attempt
+	for i := 0; i < 100; i++ {
+		if job, _ := jobManager.Get(flaky.ID); strings.Contains(job.ExecInfo, "will start") {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	quick := testJob("quick")
+	if err := jobManager.Submit(quick); err != nil {
+		t.Fatal(err)
+	}
+	rec := waitJob(t, flaky.ID, 10*time.Second)
+	if rec.Status != JobFinished || len(rec.Attempts) != 2 {
+		t.Fatalf("wrong job status=%s attempts=%d", rec.Status, len(rec.Attempts))
+	}
+	if first := rec.Attempts[0]; first.ExitCode != 75 || first.Log != "chap.attempt-1.log" {
+		t.Errorf("wrong first attempt %+v", first)
+	}
+	if delay := rec.Attempts[1].StartTime.Sub(rec.Attempts[0].EndTime); delay < 2*time.Second {
+		t.Errorf("next attempt started after %v", delay)
+	}
+	other := waitJob(t, quick.ID, 10*time.Second)
+	if other.Status != JobFinished || !other.EndTime.Before(rec.Attempts[1].StartTime) {
+		t.Errorf("job status=%s is finished at %v while retry started at %v", other.Status, other.EndTime, rec.Attempts[1].StartTime)
+	}
+
+	// cancelled job does not wait for its next attempt
+	broken := testJob("broken")
+	broken.Retry = RetryPolicy{MaxAttempts: 2, Backoff: "1h", ExitCodes: []int{75}}
+	if err := jobManager.Submit(broken); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if job, _ := jobManager.Get(broken.ID); strings.Contains(job.ExecInfo, "will start") {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := jobManager.Cancel(broken.ID); err != nil {
+		t.Fatal(err)
+	}
+	rec = waitJob(t, broken.ID, 5*time.Second)
+	if rec.Status != JobCancelled || len(rec.Attempts) != 1 {
+		t.Errorf("wrong job status=%s attempts=%d", rec.Status, len(rec.Attempts))
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := jobManager.ActiveJob("test", "broken"); !ok {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("cancelled job is still active")
+}
@@ -0,0 +1,336 @@
+package main
+
+// runs module provides persistent history of CHAP runs
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runsBucket defines storage bucket of CHAP runs
+const runsBucket = "runs"
+
+// configsBucket defines storage bucket of CHAP configurations of runs
+const configsBucket = "configs"
+
+// RunConfig represents CHAP configuration used by the run
+type RunConfig struct {
+	ID        string     `json:"id"`        // run (job) identifier
+	Overrides []Override `json:"overrides"` // user overrides of workflow configuration
+	Config    string     `json:"config"`    // CHAP configuration with merged overrides
+}
+
+// RunRecord represents persistent record of CHAP run
+type RunRecord struct {
+	ID         string    `json:"id"`          // run (job) identifier
+	User       string    `json:"user"`        // user name
+	Workflow   string    `json:"workflow"`    // workflow name
+	SubmitTime time.Time `json:"submit_time"` // submission time
+	StartTime  time.Time `json:"start_time"`  // start time
+	EndTime    time.Time `json:"end_time"`    // end time
+	Executor   string    `json:"executor"`    // executor name
+	Status     string    `json:"status"`      // run status
+	ExitCode   int       `json:"exit_code"`   // exit status of CHAP pipeline
+	Duration   float64   `json:"duration"`    // duration in seconds
+	ConfigHash string    `json:"config_hash"` // SHA-256 of CHAP configuration
+	Output     string    `json:"output"`      // output location of the run
+	Reason     string    `json:"reason"`      // reason of run termination
+	Pinned     bool      `json:"pinned"`      // pinned run is protected from removal
+	Profile    bool      `json:"profile"`     // run was executed in profile mode
+	Sweep      string    `json:"sweep"`       // parameter sweep of the run
+	Schedule   string    `json:"schedule"`    // schedule which fired the run
+	Chain      string    `json:"chain"`       // workflow chain of the run
+	Overrides  int       `json:"overrides"`   // number of user overrides of configuration
+	Attempts   int       `json:"attempts"`    // number of attempts of the run
+}
+
+// DurationString returns human readable duration of the run
+func (r RunRecord) DurationString() string {
+	return (time.Duration(r.Duration) * time.Second).String()
+}
+
+// helper function to calculate hash of CHAP configuration
+func configHash(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to create run record from the job
+func newRunRecord(job Job) RunRecord {
+	var duration float64
+	if !job.EndTime.IsZero() {
+		duration = job.Duration().Round(time.Second).Seconds()
+	}
+	return RunRecord{
+		ID:         job.ID,
+		User:       job.User,
+		Workflow:   job.Workflow,
+		SubmitTime: job.SubmitTime,
+		StartTime:  job.StartTime,
+		EndTime:    job.EndTime,
+		Executor:   job.Executor,
+		Status:     job.Status,
+		ExitCode:   job.ExitCode,
+		Duration:   duration,
+		ConfigHash: configHash(job.Config),
+		Output:     runURL(job.User, job.Workflow, job.ID),
+		Reason:     job.Reason,
+		Profile:    job.Profile,
+		Sweep:      job.Sweep,
+		Schedule:   job.Schedule,
+		Chain:      job.Chain,
+		Overrides:  len(job.Overrides),
+		Attempts:   len(job.Attempts),
+	}
+}
+
+// helper function to store record of the job in run history, the pin of
+// existing record is preserved
+func saveRun(job Job) {
+	if storage == nil {
+		return
+	}
+	rec := newRunRecord(job)
+	var found bool
+	err := storage.Update(rec.ID, func(data []byte) (any, error) {
+		if data != nil {
+			var old RunRecord
+			if err := json.Unmarshal(data, &old); err != nil {
+				return nil, err
+			}
+			rec.Pinned = old.Pinned
+			found = true
+		}
+		return rec, nil
+	}, runsBucket, rec.User)
+	if err != nil {
+		log.Printf("ERROR: unable to save run %s, error %v", rec.ID, err)
+		return
+	}
+	if !found {
+		// keep configuration of the run for provenance
+		cfg := RunConfig{ID: job.ID, Overrides: job.Overrides, Config: job.Config}
+		if err := storage.Put(rec.ID, cfg, configsBucket, rec.User); err != nil {
+			log.Printf("ERROR: unable to save config of run %s, error %v", rec.ID, err)
+		}
+	}
+}
+
+// helper function to modify existing run record of the user within single
+// storage transaction
+func updateRun(user, id string, f func(rec *RunRecord)) error {
+	return storage.Update(id, func(data []byte) (any, error) {
+		if data == nil {
+			return nil, ErrNotFound
+		}
+		var rec RunRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+		f(&rec)
+		return rec, nil
+	}, runsBucket, user)
+}
+
+// helper function to get CHAP configuration of the run
+func getRunConfig(user, id string) (RunConfig, error) {
+	var cfg RunConfig
+	err := storage.Get(id, &cfg, configsBucket, user)
+	return cfg, err
+}
+
+// helper function to get run records of the user, the records are sorted
+// by submission time with most recent first
+func userRuns(user string) ([]RunRecord, error) {
+	var records []RunRecord
+	err := storage.ForEach(func(key string, data []byte) error {
+		var rec RunRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		records = append(records, rec)
+		return nil
+	}, runsBucket, user)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SubmitTime.After(records[j].SubmitTime)
+	})
+	return records, err
+}
+
+// helper function to mark runs which were active during server shutdown,
+// such runs are no longer tracked by job manager
+func markStaleRuns() {
+	users, err := storage.Buckets(runsBucket)
+	if err != nil {
+		log.Println("ERROR: unable to get users of run history", err)
+		return
+	}
+	for _, user := range users {
+		records, err := userRuns(user)
+		if err != nil {
+			log.Printf("ERROR: unable to get runs of user %s, error %v", user, err)
+			continue
+		}
+		for _, rec := range records {
+			if rec.Status != JobQueued && rec.Status != JobRunning {
+				continue
+			}
+			err := updateRun(rec.User, rec.ID, func(r *RunRecord) {
+				r.Status = JobFailed
+				r.Reason = "server was restarted while run was active"
+			})
+			if err != nil {
+				log.Printf("ERROR: unable to update run %s, error %v", rec.ID, err)
+			}
+		}
+	}
+}
+
+// helper function to construct directory of the run
+func runDir(user, workflow, id string) string {
+	return filepath.Join(Config.UserDir, user, workflow, "runs", id)
+}
+
+// helper function to construct URL path of the run area
+func runURL(user, workflow, id string) string {
+	return fmt.Sprintf("%s/users/%s/%s/runs/%s", Config.Base, user, workflow, id)
+}
+
+// helper function to create directory of new run and point latest link
+// of the workflow to it
+func initRunDir(user, workflow, id string) error {
+	if err := os.MkdirAll(runDir(user, workflow, id), 0755); err != nil {
+		return err
+	}
+	return updateLatest(user, workflow, id)
+}
+
+// helper function to update latest link of the workflow, empty run id
+// removes the link
+func updateLatest(user, workflow, id string) error {
+	link := filepath.Join(Config.UserDir, user, workflow, "latest")
+	if id == "" {
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	// create new link aside and atomically replace the old one
+	tmp := link + ".new"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join("runs", id), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// helper function to find most recent run of the workflow, the run ids
+// start with timestamp and therefore are ordered by time
+func latestRun(user, workflow string) string {
+	entries, err := os.ReadDir(filepath.Join(Config.UserDir, user, workflow, "runs"))
+	if err != nil {
+		return ""
+	}
+	var latest string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() > latest {
+			latest = entry.Name()
+		}
+	}
+	return latest
+}
+
+// helper function to get run record of the user
+func getRun(user, id string) (RunRecord, error) {
+	var rec RunRecord
+	err := storage.Get(id, &rec, runsBucket, user)
+	return rec, err
+}
+
+// helper function to pin or unpin run of the user
+func pinRun(user, id string, pin bool) error {
+	err := updateRun(user, id, func(rec *RunRecord) {
+		rec.Pinned = pin
+	})
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to find run %s, error %v", id, err)
+	}
+	return err
+}
+
+// helper function to delete run of the user along with its directory,
+// the pinned, active and chained runs can not be deleted
+func deleteRun(user, id string) error {
+	rec, err := getRun(user, id)
+	if err != nil {
+		return fmt.Errorf("unable to find run %s, error %v", id, err)
+	}
+	if rec.Pinned {
+		return fmt.Errorf("run %s is pinned, please unpin it first", id)
+	}
+	chained, err := chainRuns(user)
+	if err != nil {
+		return err
+	}
+	if chain, ok := chained[id]; ok {
+		return fmt.Errorf("run %s is used by workflow chain %s", id, chain)
+	}
+	if rec.Status == JobQueued || rec.Status == JobRunning {
+		return fmt.Errorf("run %s is %s, please cancel it first", id, rec.Status)
+	}
+	if err := os.RemoveAll(runDir(rec.User, rec.Workflow, rec.ID)); err != nil {
+		return err
+	}
+	if err := storage.Delete(rec.ID, runsBucket, rec.User); err != nil {
+		return err
+	}
+	storage.Delete(rec.ID, configsBucket, rec.User)
+	log.Printf("run %s of user %s workflow %s is deleted", rec.ID, rec.User, rec.Workflow)
+	return updateLatest(rec.User, rec.Workflow, latestRun(rec.User, rec.Workflow))
+}
+
+// helper function to remove old runs of user's workflow, we keep
+// Config.KeepRuns most recent runs while pinned runs and runs used by
+// workflow chains are never removed
+func pruneRuns(user, workflow string) {
+	if Config.KeepRuns <= 0 || storage == nil {
+		return
+	}
+	records, err := userRuns(user)
+	if err != nil {
+		log.Printf("ERROR: unable to get runs of user %s, error %v", user, err)
+		return
+	}
+	chained, err := chainRuns(user)
+	if err != nil {
+		log.Printf("ERROR: unable to get chains of user %s, error %v", user, err)
+		return
+	}
+	var kept int
+	for _, rec := range records {
+		if _, ok := chained[rec.ID]; ok || rec.Workflow != workflow || rec.Pinned {
+			continue
+		}
+		if rec.Status == JobQueued || rec.Status == JobRunning {
+			continue
+		}
+		kept++
+		if kept <= Config.KeepRuns {
+			continue
+		}
+		if err := deleteRun(user, rec.ID); err != nil {
+			log.Printf("ERROR: unable to prune run %s, error %v", rec.ID, err)
+		}
+	}
+}
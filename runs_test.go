@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// helper function to create finished run of user's workflow with given
+// number, the run ids are ordered by their numbers
+func testRun(t *testing.T, workflow string, num int) Job {
+	job := Job{
+		ID:         fmt.Sprintf("20260101-%06d-abcd", num),
+		User:       "test",
+		Workflow:   workflow,
+		Status:     JobFinished,
+		SubmitTime: time.Date(2026, 1, 1, 0, 0, num, 0, time.UTC),
+		Config:     fmt.Sprintf("pipeline:\n  - common.PrintProcessor: {run: %d}\n", num),
+	}
+	job.Dir = runDir(job.User, job.Workflow, job.ID)
+	if err := initRunDir(job.User, job.Workflow, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	saveRun(job)
+	return job
+}
+
+// helper function to read latest link of the workflow
+func latestLink(workflow string) string {
+	link, _ := os.Readlink(filepath.Join(Config.UserDir, "test", workflow, "latest"))
+	return link
+}
+
+// TestRunHistory tests save, pin, delete and prune of CHAP runs
+func TestRunHistory(t *testing.T) {
+	setupStorage(t)
+	Config.UserDir = t.TempDir()
+	defer func() { Config.UserDir = "" }()
+
+	var jobs []Job
+	for i := 1; i <= 4; i++ {
+		jobs = append(jobs, testRun(t, "wflow", i))
+	}
+	testRun(t, "other", 5)
+	if link := latestLink("wflow"); link != filepath.Join("runs", jobs[3].ID) {
+		t.Errorf("wrong latest link %s", link)
+	}
+	records, err := userRuns("test")
+	if err != nil || len(records) != 5 || records[1].ID != jobs[3].ID {
+		t.Fatalf("wrong run history %+v, error %v", records, err)
+	}
+	if cfg, err := getRunConfig("test", jobs[0].ID); err != nil || cfg.Config != jobs[0].Config {
+		t.Errorf("wrong config of run %+v, error %v", cfg, err)
+	}
+
+	// pin is preserved by subsequent saves of the run
+	if err := pinRun("test", jobs[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	saveRun(jobs[0])
+	if rec, err := getRun("test", jobs[0].ID); err != nil || !rec.Pinned {
+		t.Errorf("pin of run is lost %+v, error %v", rec, err)
+	}
+	if err := pinRun("test", "unknown", true); err == nil {
+		t.Error("unknown run is pinned")
+	}
+	if err := deleteRun("test", jobs[0].ID); err == nil {
+		t.Error("pinned run is deleted")
+	}
+	running := jobs[3]
+	running.Status = JobRunning
+	saveRun(running)
+	if err := deleteRun("test", running.ID); err == nil {
+		t.Error("running run is deleted")
+	}
+	saveRun(jobs[3])
+
+	// deletion of latest run moves latest link to previous run
+	if err := deleteRun("test", jobs[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(jobs[3].Dir); !os.IsNotExist(err) {
+		t.Error("directory of deleted run still exists")
+	}
+	if _, err := getRunConfig("test", jobs[3].ID); err == nil {
+		t.Error("config of deleted run still exists")
+	}
+	if link := latestLink("wflow"); link != filepath.Join("runs", jobs[2].ID) {
+		t.Errorf("wrong latest link %s after deletion", link)
+	}
+
+	// prune keeps most recent unpinned runs of the workflow
+	Config.KeepRuns = 1
+	defer func() { Config.KeepRuns = 0 }()
+	pruneRuns("test", "wflow")
+	for i, expect := range []bool{true, false, true} {
+		if _, err := getRun("test", jobs[i].ID); (err == nil) != expect {
+			t.Errorf("run %d exists=%v, expect %v", i+1, err == nil, expect)
+		}
+	}
+	if records, _ := userRuns("test"); len(records) != 3 {
+		t.Errorf("prune removes runs of other workflows %d", len(records))
+	}
+	if link := latestLink("wflow"); link != filepath.Join("runs", jobs[2].ID) {
+		t.Errorf("wrong latest link %s after prune", link)
+	}
+}
+
+// TestPinRunConcurrent tests that pin of the run is not lost when run is
+// saved concurrently
+func TestPinRunConcurrent(t *testing.T) {
+	setupStorage(t)
+	Config.UserDir = t.TempDir()
+	defer func() { Config.UserDir = "" }()
+	job := testRun(t, "wflow", 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			saveRun(job)
+		}
+	}()
+	if err := pinRun("test", job.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if rec, err := getRun("test", job.ID); err != nil || !rec.Pinned {
+		t.Errorf("pin of run is lost %+v, error %v", rec, err)
+	}
+}
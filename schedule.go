@@ -0,0 +1,318 @@
+package main
+
+// schedule module provides scheduled and recurring CHAP runs, the
+// schedules use cron expressions and keep user code captured at
+// schedule creation time
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schedulesBucket defines storage bucket of schedules
+const schedulesBucket = "schedules"
+
+// Schedule represents recurring CHAP run of user's workflow
+type Schedule struct {
+	ID         string    `json:"id"`          // schedule identifier
+	User       string    `json:"user"`        // user name
+	Workflow   string    `json:"workflow"`    // workflow name
+	Cron       string    `json:"cron"`        // cron expression of the schedule
+	Executor   string    `json:"executor"`    // requested executor
+	Module     string    `json:"module"`      // user python module
+	Processor  string    `json:"processor"`   // user processor class
+	Lines      []string  `json:"lines"`       // user code frozen at creation time
+	Paused     bool      `json:"paused"`      // paused schedule does not fire runs
+	CreateTime time.Time `json:"create_time"` // creation time
+	LastRun    time.Time `json:"last_run"`    // time of last fired run
+	LastJob    string    `json:"last_job"`    // job id of last fired run
+	Error      string    `json:"error"`       // error of last fired run
+}
+
+// Next returns next time the schedule will fire
+func (s Schedule) Next() time.Time {
+	spec, err := parseCron(s.Cron)
+	if err != nil || s.Paused {
+		return time.Time{}
+	}
+	return spec.Next(time.Now())
+}
+
+// cronField represents allowed values of cron field
+type cronField struct {
+	min, max int
+	values   map[int]bool
+	any      bool // field is * and matches all values
+}
+
+// match checks if value is allowed by the field
+func (f cronField) match(val int) bool {
+	return f.any || f.values[val]
+}
+
+// CronSpec represents parsed cron expression in standard five fields
+// format: minute hour day-of-month month day-of-week
+type CronSpec struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// cronAliases defines predefined cron expressions
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// helper function to parse cron expression
+func parseCron(expr string) (CronSpec, error) {
+	var spec CronSpec
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return spec, fmt.Errorf("invalid cron expression '%s', it should have 5 fields", expr)
+	}
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	targets := []*cronField{&spec.minute, &spec.hour, &spec.dom, &spec.month, &spec.dow}
+	for i, field := range fields {
+		*targets[i], err = parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return spec, fmt.Errorf("invalid cron expression '%s', error %v", expr, err)
+		}
+	}
+	// both 0 and 7 represent Sunday
+	if spec.dow.values[7] {
+		spec.dow.values[0] = true
+	}
+	return spec, nil
+}
+
+// helper function to parse single cron field which may contain lists,
+// ranges and steps, e.g. 1,15 or 1-5 or */10
+func parseCronField(field string, min, max int) (cronField, error) {
+	rec := cronField{min: min, max: max, values: make(map[int]bool), any: field == "*"}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			val, err := strconv.Atoi(part[idx+1:])
+			if err != nil || val <= 0 {
+				return rec, fmt.Errorf("invalid step in '%s'", part)
+			}
+			step = val
+			part = part[:idx]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			val, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return rec, fmt.Errorf("invalid value '%s'", part)
+			}
+			lo, hi = val, val
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return rec, fmt.Errorf("invalid range '%s'", part)
+				}
+			} else if step > 1 {
+				// e.g. 5/15 means from 5 till the end with step 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return rec, fmt.Errorf("value '%s' is out of range %d-%d", part, min, max)
+		}
+		for val := lo; val <= hi; val += step {
+			rec.values[val] = true
+		}
+	}
+	return rec, nil
+}
+
+// helper function to check if day matches day-of-month and day-of-week
+// fields, if both of them are restricted the day should match either one
+func (c CronSpec) matchDay(t time.Time) bool {
+	if !c.dom.any && !c.dow.any {
+		return c.dom.match(t.Day()) || c.dow.match(int(t.Weekday()))
+	}
+	return c.dom.match(t.Day()) && c.dow.match(int(t.Weekday()))
+}
+
+// Match checks if given time (with minute precision) matches the spec
+func (c CronSpec) Match(t time.Time) bool {
+	return c.minute.match(t.Minute()) && c.hour.match(t.Hour()) &&
+		c.month.match(int(t.Month())) && c.matchDay(t)
+}
+
+// Next returns first time after given one which matches the spec, it
+// returns zero time if there is no such time within next five years
+func (c CronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month.match(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour.match(t.Hour()) {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !c.minute.match(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// scheduleLock protects updates of schedules by scheduler and handlers
+var scheduleLock sync.Mutex
+
+// helper function to save schedule in storage
+func saveSchedule(s Schedule) error {
+	return storage.Put(s.ID, s, schedulesBucket, s.User)
+}
+
+// helper function to get schedule of the user
+func getSchedule(user, id string) (Schedule, error) {
+	var s Schedule
+	err := storage.Get(id, &s, schedulesBucket, user)
+	return s, err
+}
+
+// helper function to get schedules of the user sorted by creation time
+func userSchedules(user string) ([]Schedule, error) {
+	var schedules []Schedule
+	err := storage.ForEach(func(key string, data []byte) error {
+		var s Schedule
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		schedules = append(schedules, s)
+		return nil
+	}, schedulesBucket, user)
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreateTime.After(schedules[j].CreateTime)
+	})
+	return schedules, err
+}
+
+// helper function to create new schedule
+func createSchedule(s Schedule) (Schedule, error) {
+	if s.Workflow == "" {
+		return s, errors.New("no workflow is provided")
+	}
+	if _, ok := findWorkflow(s.Workflow); !ok {
+		return s, fmt.Errorf("unknown workflow %s", s.Workflow)
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return s, err
+	}
+	s.ID = newJobID()
+	s.CreateTime = time.Now()
+	log.Printf("create schedule %s user=%s workflow=%s cron='%s'", s.ID, s.User, s.Workflow, s.Cron)
+	return s, saveSchedule(s)
+}
+
+// helper function to pause or resume schedule
+func pauseSchedule(user, id string, pause bool) (Schedule, error) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+	s, err := getSchedule(user, id)
+	if err != nil {
+		return s, fmt.Errorf("unable to find schedule %s, error %v", id, err)
+	}
+	s.Paused = pause
+	return s, saveSchedule(s)
+}
+
+// helper function to delete schedule
+func deleteSchedule(user, id string) error {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+	if _, err := getSchedule(user, id); err != nil {
+		return fmt.Errorf("unable to find schedule %s, error %v", id, err)
+	}
+	log.Printf("delete schedule %s user=%s", id, user)
+	return storage.Delete(id, schedulesBucket, user)
+}
+
+// helper function to fire schedules which match given time
+func fireSchedules(t time.Time) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+	users, err := storage.Buckets(schedulesBucket)
+	if err != nil {
+		log.Println("ERROR: unable to get users of schedules", err)
+		return
+	}
+	for _, user := range users {
+		schedules, err := userSchedules(user)
+		if err != nil {
+			log.Printf("ERROR: unable to get schedules of user %s, error %v", user, err)
+			continue
+		}
+		for _, s := range schedules {
+			spec, err := parseCron(s.Cron)
+			if err != nil || s.Paused || !spec.Match(t) || !s.LastRun.Before(t) {
+				continue
+			}
+			job, err := submitRun(RunRequest{
+				User:      s.User,
+				Workflow:  s.Workflow,
+				Module:    s.Module,
+				Processor: s.Processor,
+				Executor:  s.Executor,
+				Lines:     s.Lines,
+				Schedule:  s.ID,
+			})
+			s.LastRun = t
+			s.Error = ""
+			if err != nil {
+				s.Error = err.Error()
+				log.Printf("ERROR: schedule %s unable to submit run, error %v", s.ID, err)
+			} else {
+				s.LastJob = job.ID
+				log.Printf("schedule %s submitted job %s", s.ID, job.ID)
+			}
+			if err := saveSchedule(s); err != nil {
+				log.Printf("ERROR: unable to save schedule %s, error %v", s.ID, err)
+			}
+		}
+	}
+}
+
+// helper function to start scheduler which checks schedules every minute
+func initScheduler() {
+	go func() {
+		for {
+			now := time.Now()
+			tick := now.Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(tick.Sub(now))
+			fireSchedules(tick)
+		}
+	}()
+	log.Println("scheduler started")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper function to create workflow with given CHAP configuration in
+// workflows area
+func testWorkflow(t *testing.T, name, config string) {
+	wdir := filepath.Join(Config.WorkflowsRoot, name)
+	if err := os.MkdirAll(wdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	spec := "name: " + name + "\nconfig: pipeline.yaml\n"
+	os.WriteFile(filepath.Join(wdir, "chap.yaml"), []byte(spec), 0644)
+	os.WriteFile(filepath.Join(wdir, "pipeline.yaml"), []byte(config), 0644)
+}
+
+// TestCronSpec tests parsing and evaluation of cron expressions
+func TestCronSpec(t *testing.T) {
+	// Monday, 15 May 2023 10:07
+	now := time.Date(2023, 5, 15, 10, 7, 30, 0, time.UTC)
+	for _, rec := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2023, 5, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 5, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2023, 5, 16, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * 1-5", time.Date(2023, 5, 16, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2023, 5, 21, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	} {
+		spec, err := parseCron(rec.expr)
+		if err != nil {
+			t.Fatalf("unable to parse '%s', error %v", rec.expr, err)
+		}
+		next := spec.Next(now)
+		if !next.Equal(rec.next) {
+			t.Errorf("'%s': wrong next time %v, expect %v", rec.expr, next, rec.next)
+		}
+		if !spec.Match(next) {
+			t.Errorf("'%s': next time %v does not match", rec.expr, next)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("invalid expression '%s' is accepted", expr)
+		}
+	}
+}
+
+// TestFireSchedules tests that scheduler submits runs of due schedules
+// with their frozen user code
+func TestFireSchedules(t *testing.T) {
+	setupStorage(t)
+	Config.UserDir = t.TempDir()
+	Config.WorkflowsRoot = t.TempDir()
+	executors["local"] = NewLocalExecutor("chap.sh")
+	// job manager without workers keeps submitted jobs in the queue
+	manager := jobManager
+	jobManager = newJobManager(10)
+	defer func() {
+		jobManager = manager
+		delete(executors, "local")
+		Config.UserDir = ""
+		Config.WorkflowsRoot = ""
+	}()
+	testWorkflow(t, "wflow", "pipeline:\n  - common.PrintProcessor\n")
+
+	lines := []string{"# processor: Frozen\ndata = data[1:]"}
+	var ids []string
+	for _, cron := range []string{"0 2 * * *", "0 2 * * *", "0 3 * * *"} {
+		s, err := createSchedule(Schedule{User: "test", Workflow: "wflow", Cron: cron, Executor: "local",
+			Module: "mod", Processor: "UserProcessor", Lines: lines})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+	}
+	if _, err := pauseSchedule("test", ids[1], true); err != nil {
+		t.Fatal(err)
+	}
+
+	// only due schedule which is not paused submits its run
+	now := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	fireSchedules(now)
+	jobs := jobManager.UserJobs("test")
+	if len(jobs) != 1 {
+		t.Fatalf("wrong number of submitted jobs %d", len(jobs))
+	}
+	job := jobs[0]
+	if job.Schedule != ids[0] || strings.Join(job.Lines, "") != strings.Join(lines, "") {
+		t.Errorf("wrong job of schedule %+v", job)
+	}
+	if !strings.Contains(job.Config, "users.test.mod.Frozen") {
+		t.Errorf("job config does not use frozen user code:\n%s", job.Config)
+	}
+	s, err := getSchedule("test", ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastRun.Equal(now) || s.LastJob != job.ID || s.Error != "" {
+		t.Errorf("wrong schedule after fire %+v", s)
+	}
+
+	// schedule fires only once for the same time
+	fireSchedules(now)
+	if s, _ := getSchedule("test", ids[0]); len(jobManager.UserJobs("test")) != 1 || s.Error != "" {
+		t.Errorf("schedule is fired twice, error %s", s.Error)
+	}
+
+	// schedule fires again at its next time once previous run is finished
+	jobManager.update(jobManager.Jobs[job.ID], func(j *Job) { j.Status = JobFinished })
+	delete(jobManager.Active, job.Key())
+	fireSchedules(now.Add(24 * time.Hour))
+	if jobs := jobManager.UserJobs("test"); len(jobs) != 2 {
+		t.Errorf("schedule is not fired next day, jobs %d", len(jobs))
+	}
+	if s, _ := getSchedule("test", ids[1]); !s.LastRun.IsZero() || s.LastJob != "" {
+		t.Errorf("paused schedule is fired %+v", s)
+	}
+}
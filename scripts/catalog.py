@@ -0,0 +1,81 @@
+#!/usr/bin/env python
+"""
+Build catalog of CHAP readers, writers and processors by introspection of
+installed CHAP package. Usage:
+
+    catalog.py [catalog.json]
+
+It writes JSON list of classes with their kind and required arguments to
+given file or stdout, the file is used by catalog_file server configuration.
+"""
+
+import sys
+import json
+import inspect
+import pkgutil
+import importlib
+
+# methods of CHAP pipeline items and their kinds
+ITEM_METHODS = {'read': 'reader', 'write': 'writer', 'process': 'processor'}
+# arguments provided by CHAP pipeline itself
+PIPELINE_ARGS = ('self', 'data')
+
+
+def required(method):
+    "Return list of required arguments of CHAP item method"
+    args = []
+    for name, par in inspect.signature(method).parameters.items():
+        if name in PIPELINE_ARGS:
+            continue
+        if par.kind in (par.VAR_POSITIONAL, par.VAR_KEYWORD):
+            continue
+        if par.default is par.empty:
+            args.append(name)
+    return args
+
+
+def entry(module, name, cls):
+    "Return catalog entry of CHAP class or None"
+    for method, kind in ITEM_METHODS.items():
+        if not name.lower().endswith(kind):
+            continue
+        func = getattr(cls, method, None)
+        if func is None:
+            return None
+        path = module.__name__.replace('CHAP.', '', 1)
+        path = path.rsplit('.', 1)[0] if path.endswith(('.reader', '.writer', '.processor')) else path
+        return {'name': name, 'class': f'{path}.{name}', 'kind': kind, 'required': required(func)}
+    return None
+
+
+def catalog():
+    "Return catalog of CHAP classes"
+    import CHAP
+    entries = {}
+    for mod in pkgutil.walk_packages(CHAP.__path__, 'CHAP.'):
+        try:
+            module = importlib.import_module(mod.name)
+        except Exception as exc:
+            print(f'WARNING: unable to import {mod.name}, error {exc}', file=sys.stderr)
+            continue
+        for name, cls in inspect.getmembers(module, inspect.isclass):
+            if cls.__module__ != module.__name__:
+                continue
+            rec = entry(module, name, cls)
+            if rec:
+                entries[rec['class']] = rec
+    return sorted(entries.values(), key=lambda r: r['class'])
+
+
+def main():
+    "Main function"
+    data = json.dumps(catalog(), indent=2)
+    if len(sys.argv) > 1:
+        with open(sys.argv[1], 'w') as ostream:
+            ostream.write(data + '\n')
+    else:
+        print(data)
+
+
+if __name__ == '__main__':
+    main()
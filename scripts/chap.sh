@@ -2,14 +2,15 @@
 #
 # check user input
 if [ $# -ne 4 ]; then
-    echo "Not enough arguments, usage: chap.sh <workflow> <config> <worflows-dir> <user-dir>"
+    echo "Not enough arguments, usage: chap.sh <workflow> <config> <worflows-dir> <run-dir>"
     exit 1;
 fi
 pyver=`python -V | awk '{split($2,a,"."); print ""a[1]"."a[2]""}'`
 workflow=$1
 config=$2
 wdir=$3
-udir=$4
+rdir=$4
+sdir=$(cd $(dirname $0) && pwd)
 
 # obtain appropriate conda env for work workflow
 cenv=`cat $wdir/conda.yml | grep ^name | awk '{print $2}'`
@@ -25,14 +26,23 @@ fi
 eval "$(conda shell.bash hook)"
 conda activate $cenv
 
-# finally, cd to user directory, copy all necessary intput files and run CHAP job
-mkdir -p $udir/$workflow
-cd $udir/$workflow
-cp -f -r $wdir/* .
-CHAP $config 2>&1 1>& chap.log
-status=$?
+# finally, cd to run directory, copy all necessary intput files and run CHAP job,
+# the files already present in run directory (e.g. outputs of upstream chain
+# stages) are not overwritten
+mkdir -p $rdir
+cd $rdir
+cp -r -n $wdir/* .
+if [ -n "$CHAP_PROFILE" ]; then
+    # run CHAP under python profiler and produce its timing report
+    python -m cProfile -o chap.prof `which CHAP` $config 2>&1 1>& chap.log
+    status=$?
+    python $sdir/profile.py chap.prof $config >> chap.log 2>&1
+else
+    CHAP $config 2>&1 1>& chap.log
+    status=$?
+fi
 cat chap.log
-if [ "$status" == "1" ]; then
+if [ "$status" != "0" ]; then
     echo "CHAP command finished with status=$status" >> chap.log
-    exit $status
 fi
+exit $status
@@ -0,0 +1,153 @@
+#!/usr/bin/env python
+"""
+Convert cProfile output of CHAP pipeline into timing report and
+flamegraph compatible collapsed stacks. Usage:
+
+    profile.py <chap.prof> <chap-config.yaml>
+
+It writes profile.json and profile.collapsed next to profile file.
+"""
+
+import os
+import sys
+import json
+import pstats
+import importlib
+from collections import defaultdict
+
+import yaml
+
+# max number of functions we report
+MAX_FUNCTIONS = 200
+# max depth of collapsed stacks
+MAX_DEPTH = 100
+# methods of CHAP pipeline items
+ITEM_METHODS = ('read', 'process', 'write')
+
+
+def label(func):
+    "Return human readable label of profiled function"
+    fname, line, name = func
+    if fname == '~':
+        # built-in function, e.g. <built-in method time.sleep>
+        return name
+    short = os.path.join(os.path.basename(os.path.dirname(fname)), os.path.basename(fname))
+    return '%s (%s:%d)' % (name, short, line)
+
+
+def pipeline_items(config):
+    "Return names of pipeline items from CHAP configuration"
+    with open(config) as istream:
+        data = yaml.safe_load(istream)
+    items = data.get('pipeline', []) if isinstance(data, dict) else data
+    names = []
+    for item in items or []:
+        if isinstance(item, dict):
+            names += list(item.keys())
+        elif isinstance(item, str):
+            names.append(item)
+    return names
+
+
+def item_functions(name, stats):
+    "Return profiled functions of given pipeline item"
+    modname, _, clsname = name.rpartition('.')
+    for prefix in ('CHAP.', ''):
+        try:
+            cls = getattr(importlib.import_module(prefix + modname), clsname)
+        except Exception:
+            continue
+        funcs = []
+        for method in ITEM_METHODS:
+            code = getattr(getattr(cls, method, None), '__code__', None)
+            if code is None:
+                continue
+            key = (code.co_filename, code.co_firstlineno, code.co_name)
+            if key in stats:
+                funcs.append(key)
+        return funcs
+    # we can not import pipeline item (e.g. user processor), therefore
+    # we look up its methods by module file name
+    fname = modname.split('.')[-1] + '.py'
+    return [f for f in stats if os.path.basename(f[0]) == fname and f[2] in ITEM_METHODS]
+
+
+def report(stats, config):
+    "Create timing report of profiled functions and pipeline items"
+    total = max([v[3] for v in stats.values()] + [0])
+    functions = []
+    for func, (cc, nc, tt, ct, _) in stats.items():
+        functions.append({
+            'function': func[2],
+            'file': func[0],
+            'line': func[1],
+            'calls': nc,
+            'primitive_calls': cc,
+            'tottime': tt,
+            'cumtime': ct,
+            'percent': 100 * ct / total if total else 0,
+        })
+    functions.sort(key=lambda r: r['cumtime'], reverse=True)
+    items = []
+    for name in pipeline_items(config):
+        funcs = item_functions(name, stats)
+        cumtime = sum(stats[f][3] for f in funcs)
+        items.append({
+            'item': name,
+            'method': ', '.join(sorted(set(f[2] for f in funcs))),
+            'calls': sum(stats[f][1] for f in funcs),
+            'cumtime': cumtime,
+            'percent': 100 * cumtime / total if total else 0,
+        })
+    return {'total': total, 'items': items, 'functions': functions[:MAX_FUNCTIONS]}
+
+
+def collapse(stats):
+    "Create collapsed stacks from caller/callee graph of profiled functions"
+    children = defaultdict(dict)
+    for func, (_, _, _, _, callers) in stats.items():
+        for caller, cstats in callers.items():
+            children[caller][func] = cstats
+    stacks = defaultdict(float)
+
+    def walk(func, path, funcs, scale):
+        tt, ct = stats[func][2], stats[func][3]
+        path = path + [label(func).replace(';', ':')]
+        if tt * scale > 0:
+            stacks[';'.join(path)] += tt * scale
+        if len(path) >= MAX_DEPTH:
+            return
+        for child, cstats in children[func].items():
+            cct = stats[child][3]
+            if child in funcs or cct <= 0:
+                continue
+            # part of child time which was spent within this stack
+            cscale = scale * cstats[3] / cct
+            if cscale * cct < 1e-6:
+                continue
+            walk(child, path, funcs | {child}, cscale)
+
+    for func, (_, _, _, _, callers) in stats.items():
+        if not callers:
+            walk(func, [], {func}, 1.0)
+    # flamegraph tools expect integer sample counts, we use microseconds
+    return ['%s %d' % (s, int(v * 1e6)) for s, v in sorted(stacks.items()) if int(v * 1e6) > 0]
+
+
+def main():
+    "Main function"
+    if len(sys.argv) != 3:
+        print('Usage: profile.py <chap.prof> <chap-config.yaml>')
+        sys.exit(1)
+    pfile, config = sys.argv[1], sys.argv[2]
+    stats = pstats.Stats(pfile).stats
+    odir = os.path.dirname(os.path.abspath(pfile))
+    with open(os.path.join(odir, 'profile.json'), 'w') as ostream:
+        json.dump(report(stats, config), ostream, indent=2)
+    with open(os.path.join(odir, 'profile.collapsed'), 'w') as ostream:
+        ostream.write('\n'.join(collapse(stats)) + '\n')
+    print('CHAP profile is written to %s' % odir)
+
+
+if __name__ == '__main__':
+    main()
@@ -68,9 +68,43 @@ func bunRouter() *bunrouter.CompatRouter {
 	router.GET(base+"/chap/workflow/:workflow", ChapWorkflowHandler)
 	router.GET(base+"/chap/doc/:topic", ChapDocHandler)
 	router.GET(base+"/chap/tar/:workflow", ChapTarHandler)
+	router.GET(base+"/chap/catalog", ChapCatalogHandler)
+	router.GET(base+"/chap/processors", ChapProcessorsHandler)
+	router.GET(base+"/chap/runs", ChapRunsHandler)
+	router.GET(base+"/chap/usage", ChapUsageHandler)
+	router.GET(base+"/chap/runs/:id/profile", ChapRunProfileHandler)
+	router.GET(base+"/chap/runs/:id/manifest", ChapRunManifestHandler)
+	router.GET(base+"/chap/runs/:id/config", ChapRunConfigHandler)
+	router.GET(base+"/chap/sweeps", ChapSweepsHandler)
+	router.GET(base+"/chap/sweeps/:id", ChapSweepStatusHandler)
+	router.GET(base+"/chap/chains", ChapChainsHandler)
+	router.GET(base+"/chap/webhooks", ChapWebhooksHandler)
+	router.GET(base+"/chap/settings", ChapSettingsHandler)
+	router.GET(base+"/chap/chains/:id", ChapChainStatusHandler)
+	router.GET(base+"/chap/schedules", ChapSchedulesHandler)
+	router.GET(base+"/chap/jobs", ChapJobsHandler)
+	router.GET(base+"/chap/jobs/:id", ChapJobHandler)
+	router.GET(base+"/chap/jobs/:id/log", ChapJobLogHandler)
 
 	// POST routes
 	router.POST(base+"/chap/config/:workflow", ChapConfigHandler)
+	router.POST(base+"/chap/cancel/:workflow", ChapCancelHandler)
+	router.POST(base+"/chap/jobs/:id/cancel", ChapCancelHandler)
+	router.POST(base+"/chap/pipeline", ChapPipelineHandler)
+	router.POST(base+"/chap/sweep", ChapSweepHandler)
+	router.POST(base+"/chap/chain", ChapChainHandler)
+	router.POST(base+"/chap/webhook", ChapWebhookHandler)
+	router.POST(base+"/chap/settings", ChapSettingsHandler)
+	router.POST(base+"/chap/webhooks/:id/delete", ChapDeleteWebhookHandler)
+	router.POST(base+"/chap/webhooks/:id/test", ChapTestWebhookHandler)
+	router.POST(base+"/chap/chains/:id/rerun", ChapRerunChainHandler)
+	router.POST(base+"/chap/schedule", ChapScheduleHandler)
+	router.POST(base+"/chap/schedules/:id/pause", ChapPauseScheduleHandler)
+	router.POST(base+"/chap/schedules/:id/resume", ChapResumeScheduleHandler)
+	router.POST(base+"/chap/schedules/:id/delete", ChapDeleteScheduleHandler)
+	router.POST(base+"/chap/runs/:id/pin", ChapPinHandler)
+	router.POST(base+"/chap/runs/:id/unpin", ChapUnpinHandler)
+	router.POST(base+"/chap/runs/:id/delete", ChapDeleteRunHandler)
 
 	// auth end-points
 	// github OAuth routes
@@ -123,6 +157,20 @@ func Server() {
 	// initialize server middleware
 	initLimiter(Config.LimiterPeriod)
 
+	// initialize server storage
+	if err := initStorage(Config.StorageDir); err != nil {
+		log.Fatalf("unable to initialize storage in %s, error %v", Config.StorageDir, err)
+	}
+	markStaleRuns()
+
+	// initialize executors and job manager which runs CHAP pipelines
+	initExecutors()
+	ttl, _ := parseDuration(Config.JobTTL)
+	initJobManager(Config.JobWorkers, Config.JobQueueSize, ttl)
+	initScheduler()
+	initChains()
+	initCollector()
+
 	// setup server router
 	router := bunRouter()
 
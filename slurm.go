@@ -0,0 +1,149 @@
+package main
+
+// slurm module provides Slurm executor of CHAP pipelines
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SlurmExecutor submits CHAP pipeline to Slurm batch system
+type SlurmExecutor struct {
+	Partition string       // default partition to use
+	Options   []string     // additional sbatch options
+	acct      *acctTracker // tracker of finished jobs without accounting records
+}
+
+// NewSlurmExecutor creates new Slurm executor
+func NewSlurmExecutor(partition string, options []string) *SlurmExecutor {
+	return &SlurmExecutor{
+		Partition: partition,
+		Options:   options,
+		acct:      newAcctTracker(5 * time.Minute),
+	}
+}
+
+// Submit writes sbatch script into job directory and submits it via sbatch
+func (e *SlurmExecutor) Submit(job Job) (string, error) {
+	// workflow may request its own resources, otherwise we use defaults
+	var resources Resources
+	if w, ok := findWorkflow(job.Workflow); ok {
+		resources = w.Resources
+	}
+	if resources.Partition == "" {
+		resources.Partition = e.Partition
+	}
+	if timeout := job.Limits.TimeoutDuration(); resources.Walltime == "" && timeout > 0 {
+		resources.Walltime = batchDuration(timeout)
+	}
+	script, err := prepareBatchJob(job, "slurm.tmpl", func(tmpl TmplRecord) {
+		tmpl["Resources"] = resources
+		tmpl["Options"] = e.Options
+	})
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("sbatch", "--parsable", script)
+	cmd.Dir = job.Dir
+	out, err := cmd.CombinedOutput()
+	if Config.Verbose > 0 {
+		log.Printf("sbatch %s output %s error %v", script, string(out), err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sbatch failed with error %v, output: %s", err, string(out))
+	}
+	return parseSbatchOutput(out)
+}
+
+// helper function to parse job id from sbatch --parsable output which
+// has form <job id>[;<cluster name>]
+func parseSbatchOutput(out []byte) (string, error) {
+	id := strings.Split(strings.TrimSpace(string(out)), ";")[0]
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", fmt.Errorf("unable to parse sbatch output: %s", string(out))
+	}
+	return id, nil
+}
+
+// Status returns status of the job using squeue for active jobs and sacct
+// for finished ones
+func (e *SlurmExecutor) Status(job Job) (ExecStatus, error) {
+	out, err := exec.Command("squeue", "-h", "-j", job.ExecID, "-o", "%T").Output()
+	if state := strings.TrimSpace(string(out)); err == nil && state != "" {
+		status := ExecStatus{Status: JobRunning, Info: state}
+		if state == "PENDING" || state == "CONFIGURING" {
+			status.Status = JobQueued
+		}
+		return status, nil
+	}
+
+	// job is no longer known to squeue (or squeue reports invalid job id
+	// for finished jobs), get its accounting record
+	out, err = exec.Command("sacct", "-n", "-P", "-X", "-j", job.ExecID, "-o", "State,ExitCode").Output()
+	if err == nil {
+		if status, ok := parseSacctOutput(out); ok {
+			e.acct.forget(job.ExecID)
+			return status, nil
+		}
+	}
+	return e.acct.wait(job.ExecID), nil
+}
+
+// helper function to parse sacct output, e.g.
+// COMPLETED|0:0
+// FAILED|1:0
+// CANCELLED by 1234|0:15
+func parseSacctOutput(out []byte) (ExecStatus, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+		if len(fields) < 2 || len(strings.Fields(fields[0])) == 0 {
+			continue
+		}
+		state := strings.Fields(fields[0])[0]
+		codes := strings.Split(fields[1], ":")
+		code, _ := strconv.Atoi(codes[0])
+		status := ExecStatus{Info: state, ExitCode: code}
+		switch state {
+		case "PENDING", "RUNNING", "CONFIGURING", "COMPLETING", "REQUEUED", "RESIZING", "SUSPENDED":
+			status.Status = JobRunning
+		case "COMPLETED":
+			status.Status = JobFinished
+		case "CANCELLED":
+			status.Status = JobCancelled
+		default:
+			status.Status = JobFailed
+			status.Error = fmt.Sprintf("slurm job state %s, exit code %s", state, fields[1])
+		}
+		if status.Status == JobFinished && code != 0 {
+			status.Status = JobFailed
+			status.Error = fmt.Sprintf("exit status %d", code)
+		}
+		return status, true
+	}
+	return ExecStatus{}, false
+}
+
+// Cancel removes the job from Slurm
+func (e *SlurmExecutor) Cancel(job Job) error {
+	out, err := exec.Command("scancel", job.ExecID).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("scancel failed with error %v, output: %s", err, string(out))
+	}
+	return nil
+}
+
+// Logs returns CHAP log of the job
+func (e *SlurmExecutor) Logs(job Job) (io.ReadCloser, error) {
+	return openJobLog(job)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSlurmExecutor tests Slurm executor with fake sbatch/squeue/sacct commands
+func TestSlurmExecutor(t *testing.T) {
+	bin := t.TempDir()
+	state := filepath.Join(bin, "state")
+	fakeCommand(t, bin, "sbatch", `echo "777;chess"`)
+	fakeCommand(t, bin, "squeue", fmt.Sprintf(`if [ -f %s ]; then cat %s; fi`, state, state))
+	fakeCommand(t, bin, "sacct", `echo "FAILED|2:0"`)
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	// setup workflow with its resources and job areas
+	Config.WorkflowsRoot = t.TempDir()
+	wdir := filepath.Join(Config.WorkflowsRoot, "tomo")
+	os.MkdirAll(wdir, 0755)
+	os.WriteFile(filepath.Join(wdir, "conda.yml"), []byte("name: tomo\n"), 0644)
+	spec := "name: tomo\nconfig: pipeline.yaml\nresources:\n  memory: 16G\n  cpus: 4\n  walltime: \"01:00:00\"\n"
+	os.WriteFile(filepath.Join(wdir, "chap.yaml"), []byte(spec), 0644)
+	job := Job{ID: "test", Workflow: "tomo", Dir: t.TempDir(), Config: "pipeline: []\n"}
+
+	executor := NewSlurmExecutor("chess", nil)
+	id, err := executor.Submit(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "777" {
+		t.Fatalf("wrong job id %s", id)
+	}
+	script, err := os.ReadFile(filepath.Join(job.Dir, "batch_submit.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"--partition=chess", "--mem=16G", "--cpus-per-task=4", "--time=01:00:00", "conda activate tomo"} {
+		if !strings.Contains(string(script), line) {
+			t.Errorf("sbatch script does not contain '%s'", line)
+		}
+	}
+
+	job.ExecID = id
+	for _, rec := range []struct {
+		state  string
+		status string
+	}{{"PENDING", JobQueued}, {"RUNNING", JobRunning}, {"", JobFailed}} {
+		os.Remove(state)
+		if rec.state != "" {
+			os.WriteFile(state, []byte(rec.state), 0644)
+		}
+		status, err := executor.Status(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != rec.status {
+			t.Errorf("state %s: wrong status %s, expect %s", rec.state, status.Status, rec.status)
+		}
+		if rec.state == "" && status.ExitCode != 2 {
+			t.Errorf("wrong exit code %d", status.ExitCode)
+		}
+	}
+}
@@ -46,6 +46,13 @@ function ajaxSaveWorkflowConfig() {
             wflow,
             function(data, status){
             console.log("HTTP POST responst: "+status);
+            if (data != "success") {
+                // report warnings of pipeline validation
+                alert(data);
+            }
+        }).fail(function(xhr){
+            // report invalid pipeline configuration
+            alert(xhr.responseText);
         });
         /*
         $.ajax({
@@ -0,0 +1,244 @@
+// pipeline composer allows to build CHAP pipeline of the workflow from
+// catalog readers, processors and writers, every item keeps its class and
+// list of arguments, while UserProcessor refers to user's notebook processor
+var composerWorkflow = "";
+var composerItems = [];
+var composerCatalog = {};
+var composerDragIndex = -1;
+
+// helper function to get base URL of the server
+function ComposerBase() {
+    var bid=document.getElementById("base");
+    if (bid) {
+        return bid.value;
+    }
+    return "";
+}
+// helper function to escape HTML content
+function ComposerEscape(value) {
+    return String(value).replace(/&/g, "&amp;").replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+}
+// helper function to create composer item from class name and arguments
+function ComposerItem(cls, args) {
+    var item = {"class": cls, "args": []};
+    // default user processor is placed as UserProcessor to follow user
+    // module of the notebook, while other user processors keep their class
+    if (cls.startsWith("users.") && cls.endsWith(".UserProcessor")) {
+        item["class"] = "UserProcessor";
+    }
+    if (args) {
+        for (var key in args) {
+            var value = args[key];
+            if (typeof value != "string") {
+                value = JSON.stringify(value);
+            }
+            item.args.push({"key": key, "value": value});
+        }
+    }
+    // add required arguments of catalog class
+    var entry = composerCatalog[cls.split(".").pop().toLowerCase()];
+    if (entry && entry.required) {
+        for (var i = 0; i < entry.required.length; i++) {
+            var found = item.args.some(function(a) { return a.key == entry.required[i]; });
+            if (!found) {
+                item.args.push({"key": entry.required[i], "value": ""});
+            }
+        }
+    }
+    return item;
+}
+// load catalog and pipeline of the workflow into composer
+function ComposePipeline(wflow) {
+    composerWorkflow = wflow;
+    var headers = {headers: {"Accept": "application/json"}};
+    fetch(ComposerBase() + "/chap/catalog", headers)
+        .then(function(response) { return response.json(); })
+        .then(function(entries) {
+            composerCatalog = {};
+            if (Array.isArray(entries)) {
+                for (var i = 0; i < entries.length; i++) {
+                    composerCatalog[entries[i].name.toLowerCase()] = entries[i];
+                }
+            }
+            return fetch(ComposerBase() + "/chap/config/" + wflow, headers);
+        })
+        .then(function(response) { return response.json(); })
+        .then(function(rec) {
+            composerItems = [];
+            if (rec.items) {
+                for (var i = 0; i < rec.items.length; i++) {
+                    composerItems.push(ComposerItem(rec.items[i]["class"], rec.items[i].args));
+                }
+            }
+            ComposerStatus(rec.error ? "Unable to load pipeline: " + rec.error : "", rec.error);
+            ComposerRender();
+            return fetch(ComposerBase() + "/chap/processors", headers);
+        })
+        .then(function(response) { return response.json(); })
+        .then(function(processors) {
+            // user readers, processors and writers defined in notebooks can
+            // be placed independently
+            var id=document.getElementById("composer-processors");
+            if (!id || !Array.isArray(processors)) {
+                return
+            }
+            var html = "Your readers, processors and writers:";
+            for (var i = 0; i < processors.length; i++) {
+                var cls = ComposerEscape(processors[i]["class"]);
+                html += " <span class=\"composer-item\" draggable=\"true\" ondragstart=\"ComposerDrag(event, '" + cls + "')\">";
+                html += ComposerEscape(processors[i].name) + " (" + ComposerEscape(processors[i].kind) + ")";
+                html += " <a href=\"javascript:ComposerAdd('" + cls + "')\" title=\"add to pipeline\">+</a></span>";
+            }
+            id.innerHTML = html;
+        });
+}
+// show status message of the composer
+function ComposerStatus(msg, failed) {
+    var id=document.getElementById("composer-status");
+    if (id) {
+        id.textContent = msg;
+        id.className = failed ? "alert is-error" : "";
+    }
+}
+// render composer items
+function ComposerRender() {
+    var id=document.getElementById("composer");
+    if (!id) {
+        return
+    }
+    HideTag("doc-response");
+    id.className="show";
+    var title=document.getElementById("composer-workflow");
+    if (title) {
+        title.textContent = composerWorkflow;
+    }
+    var list=document.getElementById("composer-items");
+    var html = "";
+    for (var i = 0; i < composerItems.length; i++) {
+        var item = composerItems[i];
+        html += "<li class=\"composer-item\" draggable=\"true\" ondragstart=\"ComposerDragItem(event, " + i + ")\"";
+        html += " ondragover=\"event.preventDefault()\" ondrop=\"ComposerDrop(event, " + i + ")\">";
+        html += "<b>" + ComposerEscape(item["class"]) + "</b>";
+        if (item["class"] == "UserProcessor") {
+            html += " (notebook)";
+        }
+        html += " <a href=\"javascript:ComposerMove(" + i + ", -1)\" title=\"move up\">&uarr;</a>";
+        html += " <a href=\"javascript:ComposerMove(" + i + ", 1)\" title=\"move down\">&darr;</a>";
+        html += " <a href=\"javascript:ComposerRemove(" + i + ")\" title=\"remove\">&times;</a>";
+        html += "<table class=\"composer-args\">";
+        for (var j = 0; j < item.args.length; j++) {
+            var arg = item.args[j];
+            html += "<tr><td><input type=\"text\" value=\"" + ComposerEscape(arg.key) + "\"";
+            html += " oninput=\"composerItems[" + i + "].args[" + j + "].key=this.value\" placeholder=\"argument\"/></td>";
+            html += "<td><input type=\"text\" value=\"" + ComposerEscape(arg.value) + "\"";
+            html += " oninput=\"composerItems[" + i + "].args[" + j + "].value=this.value\" placeholder=\"value\"/></td>";
+            html += "<td><a href=\"javascript:ComposerRemoveArg(" + i + ", " + j + ")\" title=\"remove argument\">&times;</a></td></tr>";
+        }
+        html += "</table>";
+        html += "<a href=\"javascript:ComposerAddArg(" + i + ")\" class=\"button button-small button-round\">add argument</a>";
+        html += "</li>";
+    }
+    list.innerHTML = html;
+}
+// start dragging of catalog class from the menu
+function ComposerDrag(event, cls) {
+    event.dataTransfer.setData("text/plain", "class:" + cls);
+}
+// start dragging of composer item to change its position
+function ComposerDragItem(event, idx) {
+    composerDragIndex = idx;
+    event.dataTransfer.setData("text/plain", "item:" + idx);
+}
+// drop catalog class or composer item before item with given index, the
+// negative index appends it to the end of the pipeline
+function ComposerDrop(event, idx) {
+    event.preventDefault();
+    event.stopPropagation();
+    var data = event.dataTransfer.getData("text/plain");
+    if (idx < 0) {
+        idx = composerItems.length;
+    }
+    if (data.startsWith("class:")) {
+        composerItems.splice(idx, 0, ComposerItem(data.substring(6), null));
+    } else if (data.startsWith("item:") && composerDragIndex >= 0) {
+        var item = composerItems.splice(composerDragIndex, 1)[0];
+        if (composerDragIndex < idx) {
+            idx -= 1;
+        }
+        composerItems.splice(idx, 0, item);
+    }
+    composerDragIndex = -1;
+    ComposerRender();
+}
+// add catalog class to the end of the pipeline
+function ComposerAdd(cls) {
+    if (!composerWorkflow) {
+        alert("Please select workflow and open its pipeline composer first");
+        return
+    }
+    composerItems.push(ComposerItem(cls, null));
+    ComposerRender();
+}
+function ComposerMove(idx, shift) {
+    var pos = idx + shift;
+    if (pos < 0 || pos >= composerItems.length) {
+        return
+    }
+    var item = composerItems.splice(idx, 1)[0];
+    composerItems.splice(pos, 0, item);
+    ComposerRender();
+}
+function ComposerRemove(idx) {
+    composerItems.splice(idx, 1);
+    ComposerRender();
+}
+function ComposerAddArg(idx) {
+    composerItems[idx].args.push({"key": "", "value": ""});
+    ComposerRender();
+}
+function ComposerRemoveArg(idx, arg) {
+    composerItems[idx].args.splice(arg, 1);
+    ComposerRender();
+}
+// helper function to convert argument value, the JSON values like numbers,
+// lists or mappings are converted while other values are kept as strings
+function ComposerValue(value) {
+    try {
+        return JSON.parse(value);
+    } catch (e) {
+        return value;
+    }
+}
+// save composed pipeline as workflow configuration of the user
+function ComposerSave() {
+    var items = [];
+    for (var i = 0; i < composerItems.length; i++) {
+        var args = {};
+        var item = composerItems[i];
+        for (var j = 0; j < item.args.length; j++) {
+            if (item.args[j].key != "") {
+                args[item.args[j].key] = ComposerValue(item.args[j].value);
+            }
+        }
+        items.push({"class": item["class"], "args": args});
+    }
+    var body = JSON.stringify({"workflow": composerWorkflow, "items": items});
+    fetch(ComposerBase() + "/chap/pipeline", {
+        method: "POST",
+        headers: {"Content-Type": "application/json", "Accept": "application/json"},
+        body: body
+    })
+        .then(function(response) { return response.json(); })
+        .then(function(rec) {
+            if (rec.error) {
+                ComposerStatus(rec.error, true);
+                return
+            }
+            ComposerStatus("Pipeline is saved as configuration of " + composerWorkflow + " workflow", false);
+            var id=document.getElementById("composer-config");
+            if (id) {
+                id.textContent = rec.config;
+            }
+        });
+}
@@ -29,6 +29,8 @@ function AddWorkflow(wflow) {
     id.innerHTML += "&nbsp; workflow";
     href = "\"javascript:ajaxWorkflowConfig('" + wflow + "')\"";
     id.innerHTML += "<br/>show <a href=" + href + "id=\"getconfig\">config</a>"
+    href = "\"javascript:ComposePipeline('" + wflow + "')\"";
+    id.innerHTML += " or <a href=" + href + "id=\"compose\">compose pipeline</a>"
     // update hidden chap input
     var cid=document.getElementById("chapworkflow");
     if (cid) {
@@ -43,6 +45,10 @@ function RunCHAP(profile) {
         rurl = bid.value+"/chap/profile?token="+tid.value;
     } else if (profile == "batch") {
         rurl = bid.value+"/chap/batch?token="+tid.value;
+        var nid=document.getElementById("chapnotify");
+        if (nid && nid.checked) {
+            rurl += "&notify=true";
+        }
     }
     var id=document.getElementById("chapworkflow");
     if (id) {
@@ -58,6 +64,68 @@ function RunCHAP(profile) {
     window.onbeforeunload = null;
     window.location.href = rurl;
 }
+// helper function to follow CHAP log via server-sent events,
+// the log content is appended to given tag until the job is finished,
+// the optional ondone callback is called when job is finished
+function FollowLog(rurl, tag, reload, ondone) {
+    var id=document.getElementById(tag);
+    if (!id || !window.EventSource) {
+        return
+    }
+    id.className="show";
+    var source = new EventSource(rurl);
+    source.onmessage = function(e) {
+        id.textContent += e.data + "\n";
+        id.scrollTop = id.scrollHeight;
+    };
+    source.addEventListener("done", function(e) {
+        id.textContent += "### CHAP job is " + e.data + "\n";
+        source.close();
+        if (ondone) {
+            ondone(e.data);
+        }
+        if (reload) {
+            window.location.reload();
+        }
+    });
+    source.onerror = function(e) {
+        source.close();
+    };
+}
+// helper function to show files of CHAP run from its manifest
+function ShowManifest(rurl, tag) {
+    var id=document.getElementById(tag);
+    if (!id) {
+        return
+    }
+    fetch(rurl, {headers: {"Accept": "application/json"}})
+        .then(function(response) { return response.json(); })
+        .then(function(manifest) {
+            if (!manifest.files) {
+                return
+            }
+            // file names come from user runs, therefore we build DOM nodes
+            // and set their text content instead of using HTML markup
+            var title = document.createElement("h3");
+            title.textContent = "Outputs";
+            var list = document.createElement("ul");
+            for (var i = 0; i < manifest.files.length; i++) {
+                var f = manifest.files[i];
+                var link = document.createElement("a");
+                link.href = f.url;
+                link.textContent = f.path;
+                var sum = document.createElement("code");
+                sum.textContent = f.sha256;
+                var item = document.createElement("li");
+                item.appendChild(link);
+                item.appendChild(document.createTextNode(" " + f.size + " bytes, " + f.media_type + ", sha256 "));
+                item.appendChild(sum);
+                list.appendChild(item);
+            }
+            id.replaceChildren(title, list);
+            id.className = "show";
+        });
+}
 function DocResponse(doc) {
     // replace notebook and buttons with run please wait message
     HideTag("notebook");
@@ -0,0 +1,194 @@
+package main
+
+// storage module provides persistent storage of server records based on
+// embedded BoltDB database, see https://github.com/etcd-io/bbolt
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when record is not found in storage
+var ErrNotFound = errors.New("record not found")
+
+// Storage represents persistent storage of server records
+type Storage struct {
+	db *bolt.DB
+}
+
+// storage holds our server storage
+var storage *Storage
+
+// helper function to initialize server storage within given directory
+func initStorage(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	fname := filepath.Join(dir, "chapaas.db")
+	db, err := bolt.Open(fname, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	storage = &Storage{db: db}
+	log.Println("server storage", fname)
+	return nil
+}
+
+// helper function to get (and optionally create) nested bucket
+func bucket(tx *bolt.Tx, create bool, names ...string) (*bolt.Bucket, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no bucket name is provided")
+	}
+	var b *bolt.Bucket
+	var err error
+	for i, name := range names {
+		if create {
+			if i == 0 {
+				b, err = tx.CreateBucketIfNotExists([]byte(name))
+			} else {
+				b, err = b.CreateBucketIfNotExists([]byte(name))
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if i == 0 {
+			b = tx.Bucket([]byte(name))
+		} else {
+			b = b.Bucket([]byte(name))
+		}
+		if b == nil {
+			return nil, ErrNotFound
+		}
+	}
+	return b, nil
+}
+
+// Put stores JSON representation of the record under given key in
+// nested buckets
+func (s *Storage) Put(key string, rec any, buckets ...string) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, true, buckets...)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Update modifies record with given key of nested buckets within single
+// transaction, the function gets JSON data of current record (nil if record
+// does not exist) and returns new record to store
+func (s *Storage) Update(key string, f func(data []byte) (any, error), buckets ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, true, buckets...)
+		if err != nil {
+			return err
+		}
+		rec, err := f(b.Get([]byte(key)))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Get loads record for given key from nested buckets
+func (s *Storage) Get(key string, rec any, buckets ...string) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, false, buckets...)
+		if err != nil {
+			return err
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, rec)
+	})
+}
+
+// Delete removes record with given key from nested buckets
+func (s *Storage) Delete(key string, buckets ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, false, buckets...)
+		if err != nil {
+			return err
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ForEach calls given function for every record (in key order) of the
+// nested buckets, the sub-buckets are skipped
+func (s *Storage) ForEach(f func(key string, data []byte) error, buckets ...string) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, false, buckets...)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			return f(string(k), v)
+		})
+	})
+}
+
+// Buckets returns names of sub-buckets of the nested buckets
+func (s *Storage) Buckets(buckets ...string) ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if len(buckets) == 0 {
+			return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				names = append(names, string(name))
+				return nil
+			})
+		}
+		b, err := bucket(tx, false, buckets...)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				names = append(names, string(k))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+// Close closes the storage
+func (s *Storage) Close() error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage is not initialized")
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// helper function to setup server storage in temporary directory
+func setupStorage(t *testing.T) {
+	if err := initStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+		storage = nil
+	})
+}
+
+// TestStorage tests records and nested buckets of server storage
+func TestStorage(t *testing.T) {
+	setupStorage(t)
+	type record struct {
+		Name  string
+		Count int
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if err := storage.Put("1", record{Name: user, Count: 1}, "test", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.Put("2", record{Name: "alice", Count: 2}, "test", "alice")
+	var rec record
+	if err := storage.Get("2", &rec, "test", "alice"); err != nil || rec.Count != 2 {
+		t.Errorf("wrong record %+v, error %v", rec, err)
+	}
+	if err := storage.Get("3", &rec, "test", "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wrong error of unknown record %v", err)
+	}
+	if err := storage.Get("1", &rec, "test", "carol"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wrong error of unknown bucket %v", err)
+	}
+	users, err := storage.Buckets("test")
+	sort.Strings(users)
+	if err != nil || strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("wrong buckets %v, error %v", users, err)
+	}
+	var keys []string
+	storage.ForEach(func(key string, data []byte) error {
+		keys = append(keys, key)
+		return nil
+	}, "test", "alice")
+	if strings.Join(keys, ",") != "1,2" {
+		t.Errorf("wrong keys %v", keys)
+	}
+
+	// update modifies existing record and creates new one
+	for _, key := range []string{"2", "3"} {
+		err := storage.Update(key, func(data []byte) (any, error) {
+			return record{Name: "alice", Count: len(data)}, nil
+		}, "test", "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.Get("2", &rec, "test", "alice")
+	if rec.Count == 0 {
+		t.Error("update does not get existing record")
+	}
+	storage.Get("3", &rec, "test", "alice")
+	if rec.Count != 0 {
+		t.Error("update gets data of non-existing record")
+	}
+	// failed update leaves record unchanged
+	err = storage.Update("1", func(data []byte) (any, error) {
+		return nil, errors.New("failure")
+	}, "test", "alice")
+	if err == nil {
+		t.Error("error of update function is lost")
+	}
+	if err := storage.Get("1", &rec, "test", "alice"); err != nil || rec.Count != 1 {
+		t.Errorf("failed update modifies record %+v, error %v", rec, err)
+	}
+
+	if err := storage.Delete("1", "test", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Get("1", &rec, "test", "alice"); !errors.Is(err, ErrNotFound) {
+		t.Error("deleted record is found")
+	}
+}
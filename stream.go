@@ -0,0 +1,142 @@
+package main
+
+// stream module provides live streaming of CHAP job logs
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logPollInterval defines how often we check CHAP log for new content
+var logPollInterval = 500 * time.Millisecond
+
+// helper function to write server-sent event
+func writeEvent(w http.ResponseWriter, event, data string) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// helper function to wait for CHAP log of current attempt of given job,
+// it returns log reader (or nil if job finished without producing the
+// log) and attempt number
+func waitJobLog(r *http.Request, id string) (io.ReadCloser, int, error) {
+	for {
+		job, ok := jobManager.Get(id)
+		if !ok {
+			return nil, 0, fmt.Errorf("unable to find job %s", id)
+		}
+		if job.Status != JobQueued {
+			fname := fmt.Sprintf("%s/chap.log", job.Dir)
+			// skip log file left by previous attempt of the job
+			if info, err := os.Stat(fname); err == nil && !info.ModTime().Before(job.AttemptStart()) {
+				executor, err := getExecutor(job.Executor)
+				if err != nil {
+					return nil, 0, err
+				}
+				file, err := executor.Logs(job)
+				return file, len(job.Attempts), err
+			}
+			if job.Done() {
+				return nil, 0, nil
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return nil, 0, r.Context().Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// streamJobLog sends content of CHAP log of given job as server-sent events
+// until the job is finished or client is disconnected
+func streamJobLog(w http.ResponseWriter, r *http.Request, id string) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported by http writer")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		file, attempt, err := waitJobLog(r, id)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			break
+		}
+		done, err := followJobLog(w, r, flusher, id, file, attempt)
+		file.Close()
+		if err != nil || r.Context().Err() != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		writeEvent(w, "", fmt.Sprintf("\n### CHAP run is retried, attempt %d\n", attempt+1))
+		flusher.Flush()
+	}
+	job, _ := jobManager.Get(id)
+	writeEvent(w, "done", job.Status)
+	flusher.Flush()
+	return nil
+}
+
+// helper function to follow CHAP log of given job attempt, it returns true
+// when job is finished and false when new attempt of the job is started
+func followJobLog(w http.ResponseWriter, r *http.Request, flusher http.Flusher, id string, file io.Reader, attempt int) (bool, error) {
+	reader := bufio.NewReader(file)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			writeEvent(w, "", partial+strings.TrimSuffix(line, "\n"))
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			log.Printf("ERROR: unable to read log of job %s, error %v", id, err)
+			return false, err
+		}
+		partial += line
+		flusher.Flush()
+		// we reached end of log, stop if job is finished or retried
+		job, ok := jobManager.Get(id)
+		if !ok || job.Done() || len(job.Attempts) != attempt {
+			// read remaining content written after attempt completion
+			if rest, err := io.ReadAll(reader); err == nil {
+				partial += string(rest)
+			}
+			if partial != "" {
+				writeEvent(w, "", strings.TrimSuffix(partial, "\n"))
+			}
+			flusher.Flush()
+			return !ok || job.Done(), nil
+		}
+		select {
+		case <-r.Context().Done():
+			return false, nil
+		case <-time.After(logPollInterval):
+		}
+	}
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteEvent tests format of server-sent events
+func TestWriteEvent(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeEvent(w, "", "line 1\nline 2")
+	writeEvent(w, "done", "finished")
+	expect := "data: line 1\ndata: line 2\n\nevent: done\ndata: finished\n\n"
+	if w.Body.String() != expect {
+		t.Errorf("wrong events %q, expect %q", w.Body.String(), expect)
+	}
+}
+
+// TestStreamJobLog tests streaming of CHAP log of running job
+func TestStreamJobLog(t *testing.T) {
+	script := fakeChap(t, `for i in 1 2 3; do
+    echo "step $i" >> chap.log
+    sleep 0.3
+done
+printf "partial line" >> chap.log`)
+	setupJobManager(t, script)
+	interval := logPollInterval
+	logPollInterval = 50 * time.Millisecond
+	defer func() { logPollInterval = interval }()
+
+	job := testJob("wflow")
+	if err := jobManager.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/chap/jobs/"+job.ID+"/log", nil)
+	w := httptest.NewRecorder()
+	if err := streamJobLog(w, r, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ctype := w.Header().Get("Content-Type"); ctype != "text/event-stream" {
+		t.Errorf("wrong content type %s", ctype)
+	}
+	expect := "data: step 1\n\ndata: step 2\n\ndata: step 3\n\ndata: partial line\n\nevent: done\ndata: finished\n\n"
+	if body := w.Body.String(); body != expect {
+		t.Errorf("wrong stream %q, expect %q", body, expect)
+	}
+
+	// log of unknown job can not be streamed
+	w = httptest.NewRecorder()
+	if err := streamJobLog(w, r, "unknown"); err == nil || !strings.Contains(err.Error(), "unable to find job") {
+		t.Errorf("wrong error %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+// sweep module provides parameter sweeps of CHAP workflows, the sweep
+// expands matrix of parameter overrides of workflow pipeline into set of
+// CHAP configurations and runs every one of them as separate job
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// sweepsBucket defines storage bucket of parameter sweeps
+const sweepsBucket = "sweeps"
+
+// SweepParameter defines values of parameter of pipeline item
+type SweepParameter struct {
+	Item   string `json:"item"`   // pipeline item, e.g. common.IntegrationProcessor
+	Key    string `json:"key"`    // parameter of the item, nested keys are separated by dot
+	Values []any  `json:"values"` // values of the parameter
+}
+
+// Name returns name of the parameter
+func (p SweepParameter) Name() string {
+	return fmt.Sprintf("%s.%s", p.Item, p.Key)
+}
+
+// SweepRequest represents request of parameter sweep
+type SweepRequest struct {
+	Workflow   string           `json:"workflow"`   // workflow name
+	Executor   string           `json:"executor"`   // executor name
+	Parameters []SweepParameter `json:"parameters"` // matrix of parameter overrides
+}
+
+// SweepRun represents single run of parameter sweep
+type SweepRun struct {
+	JobID  string `json:"job_id"` // job id of the run
+	Values []any  `json:"values"` // parameter values in order of sweep parameters
+	Error  string `json:"error"`  // submission error
+}
+
+// Sweep represents parameter sweep of CHAP workflow
+type Sweep struct {
+	ID         string           `json:"id"`          // sweep identifier
+	User       string           `json:"user"`        // user name
+	Workflow   string           `json:"workflow"`    // workflow name
+	Executor   string           `json:"executor"`    // executor name
+	SubmitTime time.Time        `json:"submit_time"` // submission time
+	Parameters []SweepParameter `json:"parameters"`  // matrix of parameter overrides
+	Runs       []SweepRun       `json:"runs"`        // runs of the sweep
+}
+
+// SweepStatus represents status of sweep run used in sweep summary
+type SweepStatus struct {
+	SweepRun
+	Status   string `json:"status"`    // run status
+	ExitCode int    `json:"exit_code"` // run exit code
+	Output   string `json:"output"`    // output location of the run
+}
+
+// Validate checks sweep request
+func (s SweepRequest) Validate() error {
+	if s.Workflow == "" {
+		return errors.New("no workflow is provided")
+	}
+	if len(s.Parameters) == 0 {
+		return errors.New("no sweep parameters are provided")
+	}
+	total := 1
+	for _, p := range s.Parameters {
+		if p.Item == "" || p.Key == "" {
+			return errors.New("sweep parameter should specify pipeline item and its key")
+		}
+		if len(p.Values) == 0 {
+			return fmt.Errorf("no values are provided for sweep parameter %s", p.Name())
+		}
+		total *= len(p.Values)
+	}
+	if total > Config.MaxSweepRuns {
+		return fmt.Errorf("sweep has %d runs which exceeds allowed number of %d runs", total, Config.MaxSweepRuns)
+	}
+	return nil
+}
+
+// helper function to expand matrix of sweep parameters into list of
+// value combinations, the last parameter changes fastest
+func expandSweep(params []SweepParameter) [][]any {
+	combinations := [][]any{{}}
+	for _, p := range params {
+		var expanded [][]any
+		for _, combination := range combinations {
+			for _, val := range p.Values {
+				values := append(append([]any{}, combination...), val)
+				expanded = append(expanded, values)
+			}
+		}
+		combinations = expanded
+	}
+	return combinations
+}
+
+// helper function to convert combination of sweep parameter values into
+// user overrides, the value of every parameter is set for all occurrences
+// of its pipeline item
+func sweepOverrides(params []SweepParameter, values []any) []Override {
+	var overrides []Override
+	for i, p := range params {
+		overrides = append(overrides, Override{Item: p.Item, Key: p.Key, Value: values[i]})
+	}
+	return overrides
+}
+
+// helper function to save sweep in storage
+func saveSweep(sweep Sweep) error {
+	return storage.Put(sweep.ID, sweep, sweepsBucket, sweep.User)
+}
+
+// helper function to get sweep of the user
+func getSweep(user, id string) (Sweep, error) {
+	var sweep Sweep
+	err := storage.Get(id, &sweep, sweepsBucket, user)
+	return sweep, err
+}
+
+// helper function to get sweeps of the user, most recent first
+func userSweeps(user string) ([]Sweep, error) {
+	var sweeps []Sweep
+	err := storage.ForEach(func(key string, data []byte) error {
+		var sweep Sweep
+		if err := json.Unmarshal(data, &sweep); err != nil {
+			return err
+		}
+		sweeps = append(sweeps, sweep)
+		return nil
+	}, sweepsBucket, user)
+	sort.Slice(sweeps, func(i, j int) bool {
+		return sweeps[i].SubmitTime.After(sweeps[j].SubmitTime)
+	})
+	return sweeps, err
+}
+
+// helper function to provide status of every run of the sweep
+func sweepStatus(sweep Sweep) []SweepStatus {
+	var records []SweepStatus
+	for _, run := range sweep.Runs {
+		rec := SweepStatus{SweepRun: run, Status: JobFailed}
+		if run.JobID != "" {
+			if job, ok := jobManager.Get(run.JobID); ok {
+				rec.Status = job.Status
+				rec.ExitCode = job.ExitCode
+				rec.Output = runURL(job.User, job.Workflow, job.ID)
+			} else if r, err := getRun(sweep.User, run.JobID); err == nil {
+				rec.Status = r.Status
+				rec.ExitCode = r.ExitCode
+				rec.Output = r.Output
+			} else {
+				rec.Status = "unknown"
+			}
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// helper function to submit parameter sweep, every combination of
+// parameter values is submitted as separate job
+func submitSweep(user, module, processor string, lines []string, req SweepRequest) (Sweep, error) {
+	sweep := Sweep{
+		ID:         newJobID(),
+		User:       user,
+		Workflow:   req.Workflow,
+		Executor:   req.Executor,
+		SubmitTime: time.Now(),
+		Parameters: req.Parameters,
+	}
+	if err := req.Validate(); err != nil {
+		return sweep, err
+	}
+	if err := checkQuota(user); err != nil {
+		return sweep, err
+	}
+	config, err := genUserConfig(user, module, req.Workflow, parseUserCode(lines, processor))
+	if err != nil {
+		return sweep, err
+	}
+	combinations := expandSweep(req.Parameters)
+
+	// prepare all jobs before we submit any of them
+	var jobs []*Job
+	for _, values := range combinations {
+		// overrides of every run are kept in its configuration record
+		overrides := sweepOverrides(req.Parameters, values)
+		cfg, err := mergeOverrides(config, overrides)
+		if err != nil {
+			return sweep, err
+		}
+		job, err := newJob(user, req.Workflow, module, processor, lines, req.Executor)
+		if err != nil {
+			return sweep, err
+		}
+		job.Config = cfg
+		job.Overrides = overrides
+		job.Sweep = sweep.ID
+		if err := validateJob(job); err != nil {
+			return sweep, err
+		}
+		jobs = append(jobs, job)
+	}
+	if free := jobManager.Free(); len(jobs) > free {
+		return sweep, fmt.Errorf("sweep has %d runs while job queue has only %d free slots", len(jobs), free)
+	}
+	for i, job := range jobs {
+		run := SweepRun{Values: combinations[i]}
+		if err := jobManager.Submit(job); err != nil {
+			run.Error = err.Error()
+		} else {
+			run.JobID = job.ID
+		}
+		sweep.Runs = append(sweep.Runs, run)
+	}
+	log.Printf("sweep %s of user %s workflow %s submitted %d runs", sweep.ID, user, req.Workflow, len(sweep.Runs))
+	return sweep, saveSweep(sweep)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestExpandSweep tests expansion of sweep parameters into value combinations
+func TestExpandSweep(t *testing.T) {
+	params := []SweepParameter{
+		{Item: "common.IntegrationProcessor", Key: "radial_min", Values: []any{0.1, 0.2}},
+		{Item: "common.IntegrationProcessor", Key: "config.bins", Values: []any{10, 20, 30}},
+	}
+	combinations := expandSweep(params)
+	if len(combinations) != 6 {
+		t.Fatalf("wrong number of combinations %d", len(combinations))
+	}
+	// the last parameter changes fastest
+	expect := "[0.1 10] [0.1 20] [0.1 30] [0.2 10] [0.2 20] [0.2 30]"
+	if got := strings.Trim(fmt.Sprintf("%v", combinations), "[]"); got != strings.Trim(expect, "[]") {
+		t.Errorf("wrong combinations %v, expect %s", combinations, expect)
+	}
+
+	// every combination is applied as user overrides
+	config := "pipeline:\n  - common.YAMLReader\n  - common.IntegrationProcessor:\n      radial_min: 0.0\n"
+	overrides := sweepOverrides(params, combinations[5])
+	if len(overrides) != 2 || overrides[1].Name() != "common.IntegrationProcessor.config.bins" {
+		t.Fatalf("wrong overrides %+v", overrides)
+	}
+	out, err := mergeOverrides(config, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"radial_min: 0.2", "bins: 30"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("configuration does not contain '%s':\n%s", s, out)
+		}
+	}
+	if _, err := mergeOverrides(config, sweepOverrides([]SweepParameter{{Item: "common.Unknown", Key: "x"}}, []any{1})); err == nil {
+		t.Error("override of unknown pipeline item is applied")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+// usercode module provides parsing of user code captured from notebook
+// cells into user readers, processors and writers. Every cell which starts
+// with marker comment
+//
+//	# processor: PreFilter
+//	# reader: DetectorReader
+//	# writer: FacilityWriter
+//
+// defines body of separate user pipeline item of given kind and class name,
+// the marker can be also provided by cell metadata, e.g.
+// {"chap": {"kind": "reader", "class": "DetectorReader"}}. The marked cells
+// which define class of their marker are used as is, and if any cell defines
+// default user processor, e.g. UserProcessor, all cells are used as is and
+// the kind of their classes is detected from their read, write or process
+// methods. Otherwise remaining cells form body of default user processor.
+// All user classes are generated in user module and referred in CHAP
+// pipeline as users.<user>.<module>.<class>.
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// userItemMarker matches marker comment of user pipeline item cell
+var userItemMarker = regexp.MustCompile(`^\s*#\s*(processor|reader|writer):\s*([A-Za-z_]\w*)\s*$`)
+
+// classPattern matches class definitions of python code
+var classPattern = regexp.MustCompile(`(?m)^class\s+([A-Za-z_]\w*)\s*[(:]`)
+
+// methodPattern matches methods of CHAP pipeline items
+var methodPattern = regexp.MustCompile(`(?m)^\s+def\s+(read|write|process)\s*\(`)
+
+// itemTemplates defines templates of user pipeline items and their class
+// name parameters
+var itemTemplates = map[string][2]string{
+	KindReader:    {"reader.tmpl", "UserReader"},
+	KindWriter:    {"writer.tmpl", "UserWriter"},
+	KindProcessor: {"processor.tmpl", "UserProcessor"},
+}
+
+// UserItemCode represents body of user pipeline item generated from template
+type UserItemCode struct {
+	Name  string   // class name of user pipeline item
+	Kind  string   // reader, writer or processor
+	Lines []string // code of read, write or process method
+}
+
+// UserCode represents user code captured from notebook cells
+type UserCode struct {
+	Module  string            // python code used as is
+	Items   []UserItemCode    // pipeline items generated from templates
+	Classes []string          // all classes of user module
+	Kinds   map[string]string // kinds of user classes
+}
+
+// helper function to convert metadata of notebook cell into marker comment
+// of user pipeline item
+func cellMarker(cell Cell) string {
+	meta, ok := cell.Metadata["chap"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	kind, _ := meta["kind"].(string)
+	class, _ := meta["class"].(string)
+	if _, ok := itemTemplates[kind]; !ok || class == "" {
+		return ""
+	}
+	return fmt.Sprintf("# %s: %s", kind, class)
+}
+
+// helper function to detect kind of python classes from their methods, the
+// classes without pipeline methods are detected by their names
+func classKinds(code string) map[string]string {
+	kinds := make(map[string]string)
+	matches := classPattern.FindAllStringSubmatchIndex(code, -1)
+	for i, m := range matches {
+		name := code[m[2]:m[3]]
+		end := len(code)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		kind := classKind(name)
+		if method := methodPattern.FindStringSubmatch(code[m[1]:end]); method != nil {
+			kind = map[string]string{"read": KindReader, "write": KindWriter, "process": KindProcessor}[method[1]]
+		}
+		kinds[name] = kind
+	}
+	return kinds
+}
+
+// helper function to check if python code defines class with given name
+func definesClass(code, name string) bool {
+	for _, m := range classPattern.FindAllStringSubmatch(code, -1) {
+		if m[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to parse user code of notebook cells, the processor is
+// class name of default user processor. The cells are used as is only if
+// they define class of their marker or default user processor, while other
+// class definitions (e.g. helper classes) stay in body of default processor.
+func parseUserCode(cells []string, processor string) UserCode {
+	code := UserCode{Kinds: make(map[string]string)}
+	var module, defined, body []string
+	marked := make(map[string]string)
+	var verbatim bool
+	for _, cell := range cells {
+		first, rest := strings.TrimLeft(cell, " \t\r\n"), ""
+		if idx := strings.Index(first, "\n"); idx != -1 {
+			first, rest = first[:idx], first[idx+1:]
+		}
+		if m := userItemMarker.FindStringSubmatch(first); m != nil {
+			if definesClass(rest, m[2]) {
+				// marked cell defines its own class
+				marked[m[2]] = m[1]
+				defined = append(defined, cell)
+				module = append(module, cell)
+				continue
+			}
+			code.Items = append(code.Items, UserItemCode{Name: m[2], Kind: m[1], Lines: []string{rest}})
+			continue
+		}
+		if definesClass(cell, processor) {
+			verbatim = true
+		} else {
+			body = append(body, cell)
+		}
+		module = append(module, cell)
+	}
+	if verbatim {
+		// user defines default processor and we use all cells as is
+		code.Module = strings.Join(module, "\n")
+	} else {
+		code.Module = strings.Join(defined, "\n")
+		if len(body) > 0 || (len(code.Items) == 0 && len(defined) == 0) {
+			item := UserItemCode{Name: processor, Kind: KindProcessor, Lines: body}
+			code.Items = append([]UserItemCode{item}, code.Items...)
+		}
+	}
+	if code.Module != "" {
+		for _, m := range classPattern.FindAllStringSubmatch(code.Module, -1) {
+			code.Classes = append(code.Classes, m[1])
+		}
+		code.Kinds = classKinds(code.Module)
+		for name, kind := range marked {
+			code.Kinds[name] = kind
+		}
+	}
+	for _, item := range code.Items {
+		code.Classes = append(code.Classes, item.Name)
+		code.Kinds[item.Name] = item.Kind
+	}
+	return code
+}
+
+// Validate checks that user classes have unique names
+func (c UserCode) Validate() error {
+	seen := make(map[string]bool)
+	for _, name := range c.Classes {
+		if seen[name] {
+			return fmt.Errorf("user class %s is defined more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// ClassesOf returns user classes of given kind in order of their definition
+func (c UserCode) ClassesOf(kind string) []string {
+	var classes []string
+	for _, name := range c.Classes {
+		if c.Kinds[name] == kind {
+			classes = append(classes, name)
+		}
+	}
+	return classes
+}
+
+// helper function to construct class path of user class
+func userClass(user, module, class string) string {
+	return fmt.Sprintf("users.%s.%s.%s", user, module, class)
+}
+
+// helper function to place user pipeline items into the pipeline unless it
+// already refers to any of them, the user readers are placed after readers
+// of the pipeline, user processors before its first writer and user writers
+// at the end of the pipeline
+func placeUserItems(p *Pipeline, user, module string, code UserCode) error {
+	prefix := userClass(user, module, "")
+	classes := p.Classes()
+	for _, class := range classes {
+		if strings.HasPrefix(class, prefix) {
+			return nil
+		}
+	}
+	// find position after leading readers and position of first writer
+	readers, writer := 0, len(classes)
+	for idx, class := range classes {
+		kind := classKind(className(class))
+		if kind == KindReader && readers == idx {
+			readers = idx + 1
+		}
+		if kind == KindWriter && writer == len(classes) {
+			writer = idx
+		}
+	}
+	for _, name := range code.ClassesOf(KindReader) {
+		if err := p.Insert(readers, userClass(user, module, name), nil); err != nil {
+			return err
+		}
+		readers++
+		writer++
+	}
+	for _, name := range code.ClassesOf(KindProcessor) {
+		if err := p.Insert(writer, userClass(user, module, name), nil); err != nil {
+			return err
+		}
+		writer++
+	}
+	for _, name := range code.ClassesOf(KindWriter) {
+		if err := p.Append(userClass(user, module, name), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to check that user classes of the pipeline exist in user
+// code, it returns pipeline issues of unknown user classes
+func validateUserClasses(p *Pipeline, user, module string, code UserCode) []PipelineIssue {
+	var issues []PipelineIssue
+	prefix := userClass(user, module, "")
+	for _, item := range p.Items() {
+		if !strings.HasPrefix(item.Class, "users.") {
+			continue
+		}
+		if !strings.HasPrefix(item.Class, prefix) {
+			issues = append(issues, PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityError,
+				Message: fmt.Sprintf("user class %s does not belong to user module %s", item.Class, strings.TrimSuffix(prefix, "."))})
+			continue
+		}
+		name := strings.TrimPrefix(item.Class, prefix)
+		if _, ok := code.Kinds[name]; !ok {
+			issues = append(issues, PipelineIssue{Line: item.Line, Item: item.Class, Severity: SeverityError,
+				Message: fmt.Sprintf("user class %s is not defined in user code, known classes are %s",
+					name, strings.Join(code.Classes, ", "))})
+		}
+	}
+	return issues
+}
+
+// helper function to validate CHAP configuration of the job against CHAP
+// catalog and user classes generated from job user code, it also checks
+// resource limits of the job which may come from workflow chap.yaml
+func validateJob(job *Job) error {
+	if err := job.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits of workflow %s, error %v", job.Workflow, err)
+	}
+	if _, err := validateConfig(job.Config); err != nil {
+		return err
+	}
+	code := parseUserCode(job.Lines, job.Processor)
+	if err := code.Validate(); err != nil {
+		return err
+	}
+	pipeline, err := parsePipeline([]byte(job.Config))
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, issue := range validateUserClasses(pipeline, job.User, job.Module, code) {
+		errs = append(errs, issue.String())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid CHAP pipeline, %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
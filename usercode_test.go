@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestUserCode tests parsing of user code into user processors
+func TestUserCode(t *testing.T) {
+	// plain cells form body of default user processor
+	code := parseUserCode([]string{"import numpy", "data = data"}, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor" || len(code.Items[0].Lines) != 2 {
+		t.Errorf("wrong user code %+v", code)
+	}
+
+	// empty code still provides default user processor
+	code = parseUserCode(nil, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor" {
+		t.Errorf("wrong user code %+v", code)
+	}
+
+	// marked cells define separate user processors
+	cells := []string{
+		"\n# processor: PreFilter\ndata = [d for d in data if d]",
+		"print(data)",
+		"#processor: PostFit\nimport scipy\ndata = scipy.fit(data)",
+	}
+	code = parseUserCode(cells, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor,PreFilter,PostFit" {
+		t.Errorf("wrong user classes %v", code.Classes)
+	}
+	if body := code.Items[2].Lines[0]; body != "import scipy\ndata = scipy.fit(data)" {
+		t.Errorf("wrong body of PostFit processor %q", body)
+	}
+
+	// cells with class definitions are used as is
+	cells = []string{
+		"from CHAP.pipeline import PipelineItem",
+		"class UserProcessor(PipelineItem):\n    def process(self, data):\n        return data",
+		"class Helper:\n    pass",
+		"# processor: PreFilter\ndata = data[1:]",
+	}
+	code = parseUserCode(cells, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor,Helper,PreFilter" || len(code.Items) != 1 {
+		t.Errorf("wrong user code %+v", code)
+	}
+	if !strings.HasPrefix(code.Module, "from CHAP.pipeline") {
+		t.Errorf("wrong user module %q", code.Module)
+	}
+	if err := code.Validate(); err != nil {
+		t.Error(err)
+	}
+	code = parseUserCode(append(cells, "# processor: PreFilter\n"), "UserProcessor")
+	if err := code.Validate(); err == nil {
+		t.Error("no error for duplicate user processor")
+	}
+
+	// helper class of plain cell stays in body of default user processor
+	cells = []string{"class Helper:\n    scale = 2", "data = data * Helper.scale"}
+	code = parseUserCode(cells, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor" || code.Module != "" || len(code.Items[0].Lines) != 2 {
+		t.Errorf("wrong user code with helper class %+v", code)
+	}
+
+	// marked cell which defines class of its marker is used as is
+	cells = append(cells, "# reader: Source\nclass Source(PipelineItem):\n    def process(self, data):\n        return data")
+	code = parseUserCode(cells, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "Source,UserProcessor" || code.Kinds["Source"] != KindReader {
+		t.Errorf("wrong user code with marked class %+v", code)
+	}
+	if !strings.Contains(code.Module, "class Source(PipelineItem)") || strings.Contains(code.Module, "Helper") {
+		t.Errorf("wrong user module %q", code.Module)
+	}
+
+	// pipeline refers only to generated user processors
+	config := `pipeline:
+  - common.YAMLReader
+  - users.test.mod.PreFilter
+  - users.test.mod.PostFit
+  - users.other.mod.UserProcessor
+  - users.test.mod.UserProcessor
+`
+	pipeline, err := parsePipeline([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = parseUserCode([]string{"# processor: PreFilter\n", "data = data"}, "UserProcessor")
+	issues := validateUserClasses(pipeline, "test", "mod", code)
+	if len(issues) != 2 || issues[0].Line != 4 || issues[1].Line != 5 {
+		t.Errorf("wrong issues %v", issues)
+	}
+}
+
+// TestUserReadersWriters tests user readers and writers of user code
+func TestUserReadersWriters(t *testing.T) {
+	cells := []string{
+		"# reader: DetectorReader\ndata = open(filename).read()",
+		"data = data[1:]",
+		"# writer: FacilityWriter\nopen(filename, 'w').write(data)",
+	}
+	// metadata of notebook cell provides marker of user item
+	cell := Cell{Source: "data = data[::-1]", Metadata: map[string]any{
+		"chap": map[string]any{"kind": "processor", "class": "Reverse"}}}
+	cells = append(cells, cellMarker(cell)+"\n"+cell.Source)
+	if marker := cellMarker(Cell{Metadata: map[string]any{"chap": map[string]any{"kind": "plot"}}}); marker != "" {
+		t.Errorf("wrong marker %s", marker)
+	}
+	code := parseUserCode(cells, "UserProcessor")
+	if strings.Join(code.Classes, ",") != "UserProcessor,DetectorReader,FacilityWriter,Reverse" {
+		t.Errorf("wrong user classes %v", code.Classes)
+	}
+	if code.Kinds["DetectorReader"] != KindReader || code.Kinds["FacilityWriter"] != KindWriter || code.Kinds["Reverse"] != KindProcessor {
+		t.Errorf("wrong kinds of user classes %v", code.Kinds)
+	}
+
+	// kind of user classes is detected from their methods and names
+	kinds := classKinds("class A(PipelineItem):\n    def read(self, filename):\n        pass\n" +
+		"class B(PipelineItem):\n    def write(self, data):\n        pass\n" +
+		"class C(PipelineItem):\n    def process(self, data):\n        pass\n" +
+		"class DataReader:\n    pass\nclass Helper:\n    pass\n")
+	expect := map[string]string{"A": KindReader, "B": KindWriter, "C": KindProcessor, "DataReader": KindReader, "Helper": ""}
+	for name, kind := range expect {
+		if kinds[name] != kind {
+			t.Errorf("wrong kind %s of class %s, expect %s", kinds[name], name, kind)
+		}
+	}
+
+	// user items are placed according to their kind
+	pipeline, err := parsePipeline([]byte("pipeline:\n  - common.YAMLReader\n  - common.PrintProcessor\n  - common.NexusWriter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := placeUserItems(pipeline, "test", "mod", code); err != nil {
+		t.Fatal(err)
+	}
+	classes := []string{
+		"common.YAMLReader",
+		"users.test.mod.DetectorReader",
+		"common.PrintProcessor",
+		"users.test.mod.UserProcessor",
+		"users.test.mod.Reverse",
+		"common.NexusWriter",
+		"users.test.mod.FacilityWriter",
+	}
+	if got := pipeline.Classes(); strings.Join(got, ",") != strings.Join(classes, ",") {
+		t.Errorf("wrong pipeline %v, expect %v", got, classes)
+	}
+	// pipeline which refers to user classes is kept as is
+	if err := placeUserItems(pipeline, "test", "mod", code); err != nil || len(pipeline.Classes()) != len(classes) {
+		t.Errorf("user classes are placed twice %v, error %v", pipeline.Classes(), err)
+	}
+
+	// user module contains classes generated from their templates
+	Config.UserDir = t.TempDir()
+	defer func() { Config.UserDir = "" }()
+	dir := t.TempDir()
+	genUserCode("test", dir, "mod", "UserProcessor", cells)
+	data, err := os.ReadFile(filepath.Join(dir, "mod.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), userCodeHeader); n != 1 || !strings.HasPrefix(string(data), userCodeHeader) {
+		t.Errorf("user module should start with single import of PipelineItem, found %d imports", n)
+	}
+	for _, s := range []string{
+		"class DetectorReader(PipelineItem):", "def read(self, filename=None, **kwargs):",
+		"class FacilityWriter(PipelineItem):", "def write(self, data, filename=None, **kwargs):",
+		"class UserProcessor(PipelineItem):", "class Reverse(PipelineItem):", "data = data[::-1]",
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("user module does not contain '%s':\n%s", s, data)
+		}
+	}
+}
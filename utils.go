@@ -152,6 +152,55 @@ func getDOI() string {
 	return fmt.Sprintf("<a href=\"https://zenodo.org/badge/latestdoi/%s\"><img src=\"https://zenodo.org/badge/%s.svg\" alt=\"DOI\"></a>", doi, doi)
 }
 
+// helper function to copy content of source directory into target one,
+// the existing files of target directory are kept intact
+func copyDir(source, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(target, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+		return copyFile(path, dst)
+	})
+}
+
+// helper function to copy file, the target directory is created if
+// necessary
+func copyFile(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, src)
+	return err
+}
+
 // Tar function creates tar ball from the source and target
 // https://golangdocs.com/tar-gzip-in-golang
 func Tar(source, target string) error {
@@ -0,0 +1,449 @@
+package main
+
+// webhook module provides webhook notifications about CHAP runs, every
+// notification is signed by HMAC-SHA256 of its payload with webhook
+// secret, failed deliveries are retried and recorded in delivery log
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// webhooksBucket defines storage bucket of user webhooks
+const webhooksBucket = "webhooks"
+
+// deliveriesBucket defines storage bucket of webhook deliveries
+const deliveriesBucket = "deliveries"
+
+// maxDeliveries defines number of deliveries kept in delivery log of the user
+const maxDeliveries = 100
+
+// adminDeliveries defines delivery log owner of admin webhooks
+const adminDeliveries = "_admin"
+
+// list of webhook events
+const (
+	EventStarted   = "started"   // run is started
+	EventSucceeded = "succeeded" // run is successfully finished
+	EventFailed    = "failed"    // run is failed
+	EventCancelled = "cancelled" // run is cancelled
+	EventTest      = "test"      // test delivery requested by the user
+)
+
+// webhookEvents lists events users may subscribe to
+var webhookEvents = []string{EventStarted, EventSucceeded, EventFailed, EventCancelled}
+
+// webhookBackoff defines delay before first retry of failed delivery
+var webhookBackoff = 5 * time.Second
+
+// webhookTimeout defines timeout of single delivery attempt
+var webhookTimeout = 10 * time.Second
+
+// Webhook represents webhook registered by the user or server admin
+type Webhook struct {
+	ID         string    `json:"id"`          // webhook identifier
+	User       string    `json:"user"`        // user name, empty for admin webhooks
+	URL        string    `json:"url"`         // URL which receives notifications
+	Secret     string    `json:"secret"`      // secret to sign notifications
+	Events     []string  `json:"events"`      // subscribed events, empty means all events
+	CreateTime time.Time `json:"create_time"` // creation time
+}
+
+// Subscribed checks if webhook is subscribed to given event
+func (h Webhook) Subscribed(event string) bool {
+	if len(h.Events) == 0 || event == EventTest {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks webhook parameters
+func (h Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL '%s', it should be http or https URL", h.URL)
+	}
+	for _, e := range h.Events {
+		found := false
+		for _, event := range webhookEvents {
+			found = found || e == event
+		}
+		if !found {
+			return fmt.Errorf("unknown webhook event '%s', supported events: %v", e, webhookEvents)
+		}
+	}
+	return nil
+}
+
+// WebhookPayload represents JSON payload of webhook notification
+type WebhookPayload struct {
+	Event      string    `json:"event"`       // notification event
+	Delivery   string    `json:"delivery"`    // delivery identifier
+	Timestamp  time.Time `json:"timestamp"`   // notification time
+	ID         string    `json:"id"`          // run identifier
+	User       string    `json:"user"`        // user name
+	Workflow   string    `json:"workflow"`    // workflow name
+	Status     string    `json:"status"`      // run status
+	ExitCode   int       `json:"exit_code"`   // exit code of CHAP pipeline
+	Reason     string    `json:"reason"`      // reason of run termination
+	Duration   float64   `json:"duration"`    // duration of the run in seconds
+	SubmitTime time.Time `json:"submit_time"` // submission time
+	StartTime  time.Time `json:"start_time"`  // start time
+	EndTime    time.Time `json:"end_time"`    // end time
+	Output     string    `json:"output"`      // URL of run area
+	Artifacts  []string  `json:"artifacts"`   // URLs of files produced by the run
+}
+
+// Delivery represents record of webhook delivery
+type Delivery struct {
+	ID         string    `json:"id"`          // delivery identifier
+	Webhook    string    `json:"webhook"`     // webhook identifier
+	User       string    `json:"user"`        // user name of the run
+	URL        string    `json:"url"`         // webhook URL
+	Event      string    `json:"event"`       // notification event
+	JobID      string    `json:"job_id"`      // job id of the run
+	Time       time.Time `json:"time"`        // time of last delivery attempt
+	Attempts   int       `json:"attempts"`    // number of delivery attempts
+	StatusCode int       `json:"status_code"` // HTTP status code of last attempt
+	Error      string    `json:"error"`       // error of last attempt
+	Success    bool      `json:"success"`     // delivery succeeded
+}
+
+// helper function to check if IP address belongs to loopback, private,
+// link-local or unspecified networks which user webhooks may not reach
+func restrictedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// helper function to check that host of user webhook does not resolve to
+// restricted address
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("unable to resolve webhook host %s, error %v", host, err)
+	}
+	for _, ip := range ips {
+		if restrictedIP(ip) {
+			return fmt.Errorf("webhook host %s resolves to internal address which is not allowed", host)
+		}
+	}
+	return nil
+}
+
+// helper function to reject connections of user webhooks to restricted
+// addresses, it protects from hosts which resolve to internal addresses
+// after webhook registration
+func webhookControl(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || restrictedIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", address)
+	}
+	return nil
+}
+
+// helper function to create HTTP client of webhook, the user webhooks are
+// not allowed to connect to internal addresses and do not use proxy
+func webhookClient(hook Webhook) *http.Client {
+	client := &http.Client{Timeout: webhookTimeout}
+	if hook.User != "" {
+		dialer := &net.Dialer{Timeout: webhookTimeout, Control: webhookControl}
+		client.Transport = &http.Transport{DialContext: dialer.DialContext}
+	}
+	return client
+}
+
+// helper function to obtain webhook event of job status
+func runEvent(status string) string {
+	switch status {
+	case JobRunning:
+		return EventStarted
+	case JobFinished:
+		return EventSucceeded
+	case JobFailed:
+		return EventFailed
+	case JobCancelled:
+		return EventCancelled
+	}
+	return ""
+}
+
+// helper function to construct public URL of given server path
+func publicURL(path string) string {
+	return Config.PublicURL + path
+}
+
+// helper function to create webhook payload for the job
+func newWebhookPayload(job Job, event string) WebhookPayload {
+	payload := WebhookPayload{
+		Event:      event,
+		Delivery:   newJobID(),
+		Timestamp:  time.Now(),
+		ID:         job.ID,
+		User:       job.User,
+		Workflow:   job.Workflow,
+		Status:     job.Status,
+		ExitCode:   job.ExitCode,
+		Reason:     job.Reason,
+		Duration:   job.Duration().Round(time.Second).Seconds(),
+		SubmitTime: job.SubmitTime,
+		StartTime:  job.StartTime,
+		EndTime:    job.EndTime,
+		Output:     publicURL(runURL(job.User, job.Workflow, job.ID)),
+	}
+	for _, path := range job.Outputs {
+		payload.Artifacts = append(payload.Artifacts, publicURL(path))
+	}
+	return payload
+}
+
+// helper function to sign webhook payload with webhook secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errDelivery is stored in delivery log of failed delivery, the details
+// of the failure are only reported in server log since they may reveal
+// internal network to the user
+var errDelivery = errors.New("webhook delivery failed, please check webhook URL and its availability")
+
+// helper function to deliver webhook notification within given number of
+// attempts, the failed delivery is retried with exponential backoff
+func deliverWebhook(hook Webhook, payload WebhookPayload, attempts int) Delivery {
+	delivery := Delivery{
+		ID:      payload.Delivery,
+		Webhook: hook.ID,
+		User:    payload.User,
+		URL:     hook.URL,
+		Event:   payload.Event,
+		JobID:   payload.ID,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("ERROR: unable to marshal payload of webhook %s delivery %s, error %v", hook.ID, delivery.ID, err)
+		delivery.Error = errDelivery.Error()
+		return delivery
+	}
+	client := webhookClient(hook)
+	if attempts <= 0 {
+		attempts = 1
+	}
+	delay := webhookBackoff
+	for delivery.Attempts < attempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		delivery.Attempts++
+		delivery.Time = time.Now()
+		delivery.StatusCode, err = postWebhook(client, hook, payload, body)
+		if err == nil {
+			delivery.Success = true
+			delivery.Error = ""
+			return delivery
+		}
+		delivery.Error = errDelivery.Error()
+		log.Printf("ERROR: webhook %s delivery %s attempt %d failed, error %v", hook.ID, delivery.ID, delivery.Attempts, err)
+	}
+	return delivery
+}
+
+// helper function to post signed payload to webhook URL
+func postWebhook(client *http.Client, hook Webhook, payload WebhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CHAPaaS-webhook")
+	req.Header.Set("X-CHAP-Event", payload.Event)
+	req.Header.Set("X-CHAP-Delivery", payload.Delivery)
+	req.Header.Set("X-CHAP-Signature", signPayload(hook.Secret, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// helper function to generate webhook secret
+func newWebhookSecret() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Println("ERROR: unable to generate webhook secret", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// helper function to create webhook of the user
+func createWebhook(hook Webhook) (Webhook, error) {
+	if err := hook.Validate(); err != nil {
+		return hook, err
+	}
+	if hook.User != "" {
+		u, _ := url.Parse(hook.URL)
+		if err := checkWebhookHost(u.Hostname()); err != nil {
+			return hook, err
+		}
+	}
+	if hook.Secret == "" {
+		hook.Secret = newWebhookSecret()
+	}
+	hook.ID = newJobID()
+	hook.CreateTime = time.Now()
+	log.Printf("create webhook %s user=%s url=%s", hook.ID, hook.User, hook.URL)
+	return hook, storage.Put(hook.ID, hook, webhooksBucket, hook.User)
+}
+
+// helper function to get webhook of the user
+func getWebhook(user, id string) (Webhook, error) {
+	var hook Webhook
+	err := storage.Get(id, &hook, webhooksBucket, user)
+	return hook, err
+}
+
+// helper function to delete webhook of the user
+func deleteWebhook(user, id string) error {
+	if _, err := getWebhook(user, id); err != nil {
+		return fmt.Errorf("unable to find webhook %s, error %v", id, err)
+	}
+	log.Printf("delete webhook %s user=%s", id, user)
+	return storage.Delete(id, webhooksBucket, user)
+}
+
+// helper function to get webhooks of the user
+func userWebhooks(user string) ([]Webhook, error) {
+	var hooks []Webhook
+	err := storage.ForEach(func(key string, data []byte) error {
+		var hook Webhook
+		if err := json.Unmarshal(data, &hook); err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+		return nil
+	}, webhooksBucket, user)
+	return hooks, err
+}
+
+// helper function to store webhook delivery in delivery log of webhook
+// owner, only maxDeliveries most recent deliveries are kept
+func saveDelivery(hook Webhook, delivery Delivery) {
+	if storage == nil {
+		return
+	}
+	owner := hook.User
+	if owner == "" {
+		owner = adminDeliveries
+	}
+	if err := storage.Put(delivery.ID, delivery, deliveriesBucket, owner); err != nil {
+		log.Printf("ERROR: unable to save delivery %s, error %v", delivery.ID, err)
+		return
+	}
+	deliveries, err := userDeliveries(owner)
+	if err != nil {
+		return
+	}
+	for i := maxDeliveries; i < len(deliveries); i++ {
+		storage.Delete(deliveries[i].ID, deliveriesBucket, owner)
+	}
+}
+
+// helper function to get delivery log of the user, most recent first
+func userDeliveries(user string) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := storage.ForEach(func(key string, data []byte) error {
+		var delivery Delivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+		return nil
+	}, deliveriesBucket, user)
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries, err
+}
+
+// helper function to notify webhooks of the server and job's user about
+// current state of the job, the notifications are delivered asynchronously
+func notifyRun(job Job) {
+	event := runEvent(job.Status)
+	if event == "" {
+		return
+	}
+	hooks := append([]Webhook{}, Config.Webhooks...)
+	if storage != nil {
+		if userHooks, err := userWebhooks(job.User); err == nil {
+			hooks = append(hooks, userHooks...)
+		} else {
+			log.Printf("ERROR: unable to get webhooks of user %s, error %v", job.User, err)
+		}
+	}
+	for _, hook := range hooks {
+		if !hook.Subscribed(event) {
+			continue
+		}
+		go func(hook Webhook) {
+			saveDelivery(hook, deliverWebhook(hook, newWebhookPayload(job, event), Config.WebhookAttempts))
+		}(hook)
+	}
+}
+
+// helper function to send test notification to webhook of the user, the
+// test notification is delivered in single attempt and its failure is
+// reported without details of remote response
+func testWebhook(user, id string) (Delivery, error) {
+	hook, err := getWebhook(user, id)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("unable to find webhook %s, error %v", id, err)
+	}
+	now := time.Now()
+	job := Job{
+		ID:         newJobID(),
+		User:       user,
+		Workflow:   "test",
+		Status:     JobFinished,
+		SubmitTime: now,
+		StartTime:  now,
+		EndTime:    now,
+	}
+	delivery := deliverWebhook(hook, newWebhookPayload(job, EventTest), 1)
+	saveDelivery(hook, delivery)
+	if !delivery.Success {
+		return delivery, errors.New("test notification is not delivered, please check delivery log")
+	}
+	return delivery, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDeliverWebhook tests signed delivery of webhook notification and its
+// retry against local HTTP listener
+func TestDeliverWebhook(t *testing.T) {
+	webhookBackoff = 10 * time.Millisecond
+	var calls int
+	var payload WebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-CHAP-Signature") != signPayload("secret", body) {
+			t.Error("wrong signature of webhook payload")
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.Unmarshal(body, &payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	Config.PublicURL = "https://chapaas.host"
+	defer func() { Config.PublicURL = "" }()
+	now := time.Now()
+	job := Job{
+		ID:        "123",
+		User:      "user",
+		Workflow:  "saxswaxs",
+		Status:    JobFinished,
+		StartTime: now.Add(-time.Minute),
+		EndTime:   now,
+		Outputs:   []string{"/users/user/saxswaxs/runs/123/chap.log"},
+	}
+	hook := Webhook{ID: "1", URL: server.URL, Secret: "secret", Events: []string{EventSucceeded}}
+	if !hook.Subscribed(runEvent(job.Status)) {
+		t.Fatal("webhook should be subscribed to succeeded event")
+	}
+	delivery := deliverWebhook(hook, newWebhookPayload(job, runEvent(job.Status)), 3)
+	if !delivery.Success || delivery.Attempts != 2 || delivery.StatusCode != http.StatusOK {
+		t.Errorf("wrong delivery %+v", delivery)
+	}
+	if payload.Event != EventSucceeded || payload.Workflow != "saxswaxs" || payload.Duration != 60 {
+		t.Errorf("wrong payload %+v", payload)
+	}
+	if len(payload.Artifacts) != 1 || payload.Artifacts[0] != "https://chapaas.host/users/user/saxswaxs/runs/123/chap.log" {
+		t.Errorf("wrong artifacts %v", payload.Artifacts)
+	}
+
+	// failed delivery is retried given number of attempts
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failed.Close()
+	hook.URL = failed.URL
+	delivery = deliverWebhook(hook, newWebhookPayload(job, EventFailed), 2)
+	if delivery.Success || delivery.Attempts != 2 || delivery.StatusCode != http.StatusInternalServerError {
+		t.Errorf("wrong failed delivery %+v", delivery)
+	}
+}
+
+// TestWebhookRestricted tests that user webhooks can not reach internal
+// addresses and test delivery does not expose remote response
+func TestWebhookRestricted(t *testing.T) {
+	for addr, restricted := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"192.168.0.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"128.84.0.1":      false,
+		"2001:db8::1":     false,
+	} {
+		if restrictedIP(net.ParseIP(addr)) != restricted {
+			t.Errorf("wrong restriction of address %s", addr)
+		}
+	}
+	if _, err := createWebhook(Webhook{User: "user", URL: "http://127.0.0.1:8080/hook"}); err == nil {
+		t.Error("user webhook of loopback address is created")
+	}
+
+	setupStorage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("user webhook reached internal address")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	hook := Webhook{ID: "1", User: "user", URL: server.URL, Secret: "secret"}
+	if err := storage.Put(hook.ID, hook, webhooksBucket, hook.User); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := testWebhook("user", hook.ID)
+	if err == nil || delivery.Success || delivery.StatusCode != 0 {
+		t.Fatalf("wrong delivery %+v", delivery)
+	}
+	if strings.Contains(err.Error(), server.URL[len("http://"):]) {
+		t.Errorf("test delivery exposes remote error %v", err)
+	}
+	// delivery log does not expose details of the failure either
+	deliveries, err := userDeliveries("user")
+	if err != nil || len(deliveries) != 1 || deliveries[0].Error != errDelivery.Error() {
+		t.Errorf("wrong delivery log %+v, error %v", deliveries, err)
+	}
+}
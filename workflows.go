@@ -3,22 +3,35 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"strings"
 	"time"
 )
 
 // Workflow define CHAP workflow record
 type Workflow struct {
-	MetaData    map[string]interface{} `json:"meta_data",yaml:"meta_data"`     // meta-data information about ML model
-	Name        string                 `json:"name",yaml:"name"`               // workflow name
-	Directory   string                 `json:"directory",yaml:"directory"`     // workflow directory
-	Config      string                 `json:"config",yaml:"config"`           // workflow configuration
-	Type        string                 `json:"type",yaml:"type"`               // workflow type
-	Group       string                 `json:"group",yaml:"group"`             // workflow group
-	Version     string                 `json:"version",yaml:"version"`         // workflow version
-	Description string                 `json:"description",yaml:"description"` // workflow description
-	Reference   string                 `json:"reference",yaml:"reference"`     // reference URL
-	UserName    string                 `json:"user_name",yaml:"user_name"`     // user name
-	UserID      string                 `json:"user_id",yaml:"user_id"`         // user id
+	MetaData    map[string]interface{} `json:"meta_data" yaml:"meta_data"`     // meta-data information about ML model
+	Name        string                 `json:"name" yaml:"name"`               // workflow name
+	Directory   string                 `json:"directory" yaml:"directory"`     // workflow directory
+	Config      string                 `json:"config" yaml:"config"`           // workflow configuration
+	Type        string                 `json:"type" yaml:"type"`               // workflow type
+	Group       string                 `json:"group" yaml:"group"`             // workflow group
+	Version     string                 `json:"version" yaml:"version"`         // workflow version
+	Description string                 `json:"description" yaml:"description"` // workflow description
+	Reference   string                 `json:"reference" yaml:"reference"`     // reference URL
+	UserName    string                 `json:"user_name" yaml:"user_name"`     // user name
+	UserID      string                 `json:"user_id" yaml:"user_id"`         // user id
+	Executor    string                 `json:"executor" yaml:"executor"`       // executor to run workflow
+	Resources   Resources              `json:"resources" yaml:"resources"`     // batch resources of workflow
+	Limits      Limits                 `json:"limits" yaml:"limits"`           // resource limits of workflow runs
+	Retry       RetryPolicy            `json:"retry" yaml:"retry"`             // retry policy of failed workflow runs
+}
+
+// Resources define batch resources requested by workflow
+type Resources struct {
+	Memory    string `json:"memory" yaml:"memory"`       // memory, e.g. 8G
+	Cpus      int    `json:"cpus" yaml:"cpus"`           // number of cpus
+	Walltime  string `json:"walltime" yaml:"walltime"`   // wall time, e.g. 02:00:00
+	Partition string `json:"partition" yaml:"partition"` // batch partition
 }
 
 // ToJSON provides string representation of Record
@@ -51,3 +64,13 @@ func (c ChapWorkflows) getWorkflows() []Workflow {
 }
 
 var chapWorkflows ChapWorkflows
+
+// helper function to find CHAP workflow by its name
+func findWorkflow(name string) (Workflow, bool) {
+	for _, w := range chapWorkflows.getWorkflows() {
+		if strings.ToUpper(w.Name) == strings.ToUpper(name) {
+			return w, true
+		}
+	}
+	return Workflow{}, false
+}